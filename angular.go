@@ -1,6 +1,7 @@
 package pike
 
 import (
+	"context"
 	"fmt"
 	"strings"
 )
@@ -12,7 +13,7 @@ func HtmlTemplateCache(module, prefix string) *Node {
 	if prefix == "//" {
 		prefix = "/"
 	}
-	f := func(in, out chan File) {
+	f := func(ctx context.Context, in, out chan File) error {
 		for file := range in {
 			file.SetData([]byte(fmt.Sprintf(`angular.module('%s').run(['$templateCache', function($templateCache) {
 	$templateCache.put('%s%s', %q);
@@ -20,6 +21,9 @@ func HtmlTemplateCache(module, prefix string) *Node {
 			file.SetExt("_tmpl.js")
 			out <- file
 		}
+		return nil
 	}
-	return NewFuncNode("html2tc", f)
+	node := NewFuncNode("html2tc", f)
+	node.Options = fmt.Sprintf("%q %q", module, prefix)
+	return node
 }
@@ -0,0 +1,123 @@
+package pike
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// Command is an external program for a CommandRunner to run.
+type Command struct {
+	// The program to run, either a path or a name to look up in PATH
+	Name string
+	// The arguments, not including the program
+	Args []string
+	// The directory to run in, or "" for the current directory
+	Dir string
+	// The data to send on stdin, or nil for none
+	Stdin []byte
+}
+
+// CommandResult is the output of a Command.
+type CommandResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// ExitError is the error for a Command that exited with a non-zero status.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// CommandRunner runs the external programs for Exec and the Nodes that are
+// built on it (Less, Coffee, Uglify, and CleanCss). If the program ran but
+// failed, Run returns the result along with an *ExitError. To run a Graph
+// with a different CommandRunner, pass the context from WithCommandRunner
+// to RunContext or RunAllContext.
+type CommandRunner interface {
+	Run(ctx context.Context, cmd *Command) (*CommandResult, error)
+	// LookPath finds a program in PATH, like exec.LookPath
+	LookPath(name string) (string, error)
+}
+
+type commandRunnerContextKey struct{}
+
+// WithCommandRunner returns a context that will run the external programs
+// of any Graphs run with it through 'runner'.
+func WithCommandRunner(ctx context.Context, runner CommandRunner) context.Context {
+	return context.WithValue(ctx, commandRunnerContextKey{}, runner)
+}
+
+// commandRunnerFrom returns the CommandRunner for a context, which is
+// OsCommandRunner if none was set.
+func commandRunnerFrom(ctx context.Context) CommandRunner {
+	if runner, ok := ctx.Value(commandRunnerContextKey{}).(CommandRunner); ok {
+		return runner
+	}
+	return OsCommandRunner{}
+}
+
+// OsCommandRunner is the default CommandRunner, which runs programs with
+// os/exec. If the context is cancelled, the process is killed and Run
+// returns the context's error. Any processes that it started are only
+// killed as well if SetToolProcessGroups is enabled.
+type OsCommandRunner struct{}
+
+func (OsCommandRunner) Run(ctx context.Context, cmd *Command) (*CommandResult, error) {
+	c := exec.Command(cmd.Name, cmd.Args...)
+	c.Dir = cmd.Dir
+	if cmd.Stdin != nil {
+		c.Stdin = bytes.NewReader(cmd.Stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	toolConfig.lock.Lock()
+	groups := toolConfig.ProcessGroups
+	toolConfig.lock.Unlock()
+	if groups {
+		newProcessGroup(c)
+	}
+	// A child that outlives the process and still has stdout open would keep
+	// Wait from returning
+	c.WaitDelay = time.Second
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if groups {
+				killProcess(c)
+			} else {
+				c.Process.Kill()
+			}
+		case <-exited:
+		}
+	}()
+	err := c.Wait()
+	close(exited)
+	result := &CommandResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result.ExitCode = exitErr.ExitCode()
+		if result.ExitCode > 0 {
+			return result, &ExitError{result.ExitCode}
+		}
+	}
+	return result, err
+}
+
+func (OsCommandRunner) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
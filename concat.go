@@ -1,9 +1,14 @@
 package pike
 
+import (
+	"context"
+	"fmt"
+)
+
 // Concat creates a node that will concatenate all processed files into a
 // single file.
 func Concat(path string) *Node {
-	f := func(in, out chan File) {
+	f := func(ctx context.Context, in, out chan File) error {
 		bigFile := NewFile("", path, make([]byte, 0))
 		for file := range in {
 			bigFile.SetData(append(bigFile.Data(), file.Data()...))
@@ -12,6 +17,9 @@ func Concat(path string) *Node {
 		if len(bigFile.Data()) > 0 {
 			out <- bigFile
 		}
+		return nil
 	}
-	return NewFuncNode("concat", f)
+	node := NewFuncNode("concat", f)
+	node.Options = fmt.Sprintf("%q", path)
+	return node
 }
@@ -0,0 +1,234 @@
+package pike
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stevearc/pike/plog"
+)
+
+const liveReloadPrefix = "/__pike/"
+
+const liveReloadScript = `(function() {
+  var source = new EventSource("/__pike/events");
+  source.addEventListener("reload", function() {
+    window.location.reload();
+  });
+  source.addEventListener("css", function() {
+    var links = document.querySelectorAll("link[rel=stylesheet]");
+    for (var i = 0; i < links.length; i++) {
+      var href = links[i].href.replace(/[?&]pikereload=\d+/, "");
+      var sep = href.indexOf("?") === -1 ? "?" : "&";
+      links[i].href = href + sep + "pikereload=" + new Date().getTime();
+    }
+  });
+})();
+`
+
+// writes collects the paths of files written by Write nodes so that the
+// DevServers can be told about them after each watch cycle.
+var writes = struct {
+	Lock    *sync.Mutex
+	Servers []*DevServer
+	Paths   []string
+}{
+	&sync.Mutex{},
+	nil,
+	nil,
+}
+
+func recordWrite(path string) {
+	writes.Lock.Lock()
+	defer writes.Lock.Unlock()
+	if len(writes.Servers) > 0 {
+		writes.Paths = append(writes.Paths, path)
+	}
+}
+
+// notifyDevServers tells all DevServers about the files that were written
+// since the last time it was called.
+func notifyDevServers() {
+	writes.Lock.Lock()
+	servers := writes.Servers
+	paths := writes.Paths
+	writes.Paths = nil
+	writes.Lock.Unlock()
+	if len(paths) == 0 {
+		return
+	}
+	for _, server := range servers {
+		server.Reload(paths)
+	}
+}
+
+// DevServer is an http.Handler that serves the files in a directory, and
+// injects a script into HTML pages that reloads them when the files change.
+// Stylesheets are reloaded in place, without reloading the page. Use it with
+// WatchAll, which will notify the server after each run that writes files.
+type DevServer struct {
+	Dir     string
+	lock    sync.Mutex
+	clients map[chan string]bool
+	files   http.Handler
+}
+
+// NewDevServer creates a DevServer for a directory and registers it to be
+// notified when Write nodes change files inside of it.
+func NewDevServer(dir string) *DevServer {
+	server := &DevServer{
+		Dir:     dir,
+		clients: make(map[chan string]bool),
+		files:   http.FileServer(http.Dir(dir)),
+	}
+	writes.Lock.Lock()
+	writes.Servers = append(writes.Servers, server)
+	writes.Lock.Unlock()
+	return server
+}
+
+// Reload notifies all connected browsers that files were written. If all
+// of the files that changed in the served directory are stylesheets, the
+// browsers will only reload their stylesheets.
+func (self *DevServer) Reload(paths []string) {
+	changed := make([]string, 0, len(paths))
+	cssOnly := true
+	dir, err := filepath.Abs(self.Dir)
+	if err != nil {
+		return
+	}
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(dir, abs)
+		// Skip files outside of the directory, but not ones that merely
+		// start with dots (e.g. "..vendor.js")
+		if err != nil || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		changed = append(changed, "/"+filepath.ToSlash(rel))
+		if filepath.Ext(p) != ".css" {
+			cssOnly = false
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	event := "reload"
+	if cssOnly {
+		event = "css"
+	}
+	data, _ := json.Marshal(changed)
+	message := fmt.Sprintf("event: %s\ndata: %s\n\n", event, data)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	plog.Debug("Sending %q to %d browsers", event, len(self.clients))
+	for client := range self.clients {
+		select {
+		case client <- message:
+		default:
+			// The client isn't keeping up, so it will miss this one
+		}
+	}
+}
+
+func (self *DevServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case liveReloadPrefix + "events":
+		self.serveEvents(w, r)
+	case liveReloadPrefix + "livereload.js":
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Write([]byte(liveReloadScript))
+	default:
+		if !self.serveHtml(w, r) {
+			self.files.ServeHTTP(w, r)
+		}
+	}
+}
+
+// serveEvents streams reload events to a browser using Server-Sent Events.
+func (self *DevServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	client := make(chan string, 10)
+	self.lock.Lock()
+	self.clients[client] = true
+	self.lock.Unlock()
+	defer func() {
+		self.lock.Lock()
+		delete(self.clients, client)
+		self.lock.Unlock()
+	}()
+	w.Write([]byte(": connected\n\n"))
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case message := <-client:
+			w.Write([]byte(message))
+		case <-keepAlive.C:
+			w.Write([]byte(": ping\n\n"))
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// serveHtml serves HTML files with the live reload script injected. It
+// returns false if the request is not for an HTML file.
+func (self *DevServer) serveHtml(w http.ResponseWriter, r *http.Request) bool {
+	name := path.Clean("/" + r.URL.Path)
+	fullpath := filepath.Join(self.Dir, filepath.FromSlash(name))
+	info, err := os.Stat(fullpath)
+	if err != nil {
+		return false
+	}
+	if info.IsDir() {
+		// Let the file server handle the redirect to add a trailing slash
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			return false
+		}
+		fullpath = filepath.Join(fullpath, "index.html")
+		if info, err = os.Stat(fullpath); err != nil {
+			return false
+		}
+	}
+	ext := filepath.Ext(fullpath)
+	if ext != ".html" && ext != ".htm" {
+		return false
+	}
+	data, err := ioutil.ReadFile(fullpath)
+	if err != nil {
+		return false
+	}
+	tag := []byte(fmt.Sprintf("<script src=%q></script>", liveReloadPrefix+"livereload.js"))
+	if i := bytes.LastIndex(bytes.ToLower(data), []byte("</body>")); i >= 0 {
+		data = append(data[:i:i], append(tag, data[i:]...)...)
+	} else {
+		data = append(data, tag...)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, fullpath, info.ModTime(), bytes.NewReader(data))
+	return true
+}
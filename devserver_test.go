@@ -0,0 +1,33 @@
+package pike
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloadPaths(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &DevServer{Dir: "build", clients: make(map[chan string]bool)}
+	client := make(chan string, 10)
+	server.clients[client] = true
+
+	server.Reload([]string{
+		filepath.Join(wd, "build", "app.js"),
+		filepath.Join("build", "..vendor.js"),
+		filepath.Join("other", "b.js"),
+		"build-old.js",
+	})
+	select {
+	case message := <-client:
+		if !strings.Contains(message, `["/app.js","/..vendor.js"]`) {
+			t.Errorf("Unexpected message %q", message)
+		}
+	default:
+		t.Fatal("Expected a reload message")
+	}
+}
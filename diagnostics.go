@@ -0,0 +1,230 @@
+package pike
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a problem that an external program found in a file, such as
+// a syntax error.
+type Diagnostic struct {
+	File string
+	// Line and Column start at 1, and are 0 if the program didn't say
+	Line    int
+	Column  int
+	Message string
+	// The text of the line that the problem is on, if it is known
+	Source string
+}
+
+// String formats the Diagnostic like a compiler error, followed by the
+// source line with a caret under the column.
+func (d Diagnostic) String() string {
+	location := d.File
+	if d.Line > 0 {
+		location += fmt.Sprintf(":%d", d.Line)
+		if d.Column > 0 {
+			location += fmt.Sprintf(":%d", d.Column)
+		}
+	}
+	lines := []string{fmt.Sprintf("%s: %s", location, d.Message)}
+	if d.Source != "" {
+		gutter := strconv.Itoa(d.Line)
+		lines = append(lines, fmt.Sprintf("%s | %s", gutter, d.Source))
+		if d.Column > 0 {
+			// Keep any tabs so the caret lines up
+			var caret []rune
+			for _, r := range d.Source {
+				if len(caret) >= d.Column-1 {
+					break
+				}
+				if r == '\t' {
+					caret = append(caret, '\t')
+				} else {
+					caret = append(caret, ' ')
+				}
+			}
+			lines = append(lines, fmt.Sprintf("%s | %s^",
+				strings.Repeat(" ", len(gutter)), string(caret)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ToolError is the error for an external program that failed on a File.
+// Diagnostics are the problems parsed from what the program wrote to
+// stderr. If none could be parsed, the message includes Stderr instead.
+// Attempts is how many times the program was run, including retries.
+type ToolError struct {
+	Tool        string
+	Err         error
+	Stderr      []byte
+	Diagnostics []Diagnostic
+	Attempts    int
+}
+
+func (e *ToolError) Error() string {
+	header := fmt.Sprintf("error running %s: %v", e.Tool, e.Err)
+	if e.Attempts > 1 {
+		header = fmt.Sprintf("error running %s (tried %d times): %v", e.Tool,
+			e.Attempts, e.Err)
+	}
+	lines := []string{header}
+	lines = append(lines, describeStderr(e.Diagnostics, e.Stderr)...)
+	return strings.Join(lines, "\n")
+}
+
+// describeStderr formats the Diagnostics from a program, or its stderr if
+// there are none, as indented lines.
+func describeStderr(diagnostics []Diagnostic, stderr []byte) []string {
+	lines := make([]string, 0, len(diagnostics))
+	if len(diagnostics) > 0 {
+		for _, d := range diagnostics {
+			lines = append(lines, indent(d.String(), "  "))
+		}
+	} else if text := strings.TrimSpace(stripAnsi(string(stderr))); text != "" {
+		lines = append(lines, indent(text, "  "))
+	}
+	return lines
+}
+
+func indent(text, prefix string) string {
+	return prefix + strings.Replace(text, "\n", "\n"+prefix, -1)
+}
+
+var ansiRegex = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func stripAnsi(text string) string {
+	return ansiRegex.ReplaceAllString(text, "")
+}
+
+// A diagnosticParser finds the Diagnostics in the stderr of a program.
+type diagnosticParser func(stderr string) []Diagnostic
+
+// diagnosticParsers are the formats that ExecConfig.Diagnostics can name.
+var diagnosticParsers = map[string]diagnosticParser{
+	"lessc":    parseLessc,
+	"coffee":   parseCoffee,
+	"uglifyjs": parseUglify,
+	"cleancss": parseCleanCss,
+	"gcc":      parseGcc,
+}
+
+func atoi(text string) int {
+	value, _ := strconv.Atoi(text)
+	return value
+}
+
+// ParseError: Unrecognised input in /app/a.less on line 3, column 5:
+var lesscRegex = regexp.MustCompile(`(?m)^(?:\w+Error: )?(.*?) in (\S+) on line (\d+), column (\d+):`)
+
+func parseLessc(stderr string) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0, 1)
+	for _, m := range lesscRegex.FindAllStringSubmatch(stderr, -1) {
+		diagnostics = append(diagnostics, Diagnostic{File: m[2], Line: atoi(m[3]),
+			Column: atoi(m[4]), Message: m[1]})
+	}
+	return diagnostics
+}
+
+// a.coffee:3:5: error: unexpected indentation
+var coffeeRegex = regexp.MustCompile(`(?m)^(.+?):(\d+):(\d+): error: (.*)$`)
+
+func parseCoffee(stderr string) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0, 1)
+	for _, m := range coffeeRegex.FindAllStringSubmatch(stderr, -1) {
+		diagnostics = append(diagnostics, Diagnostic{File: m[1], Line: atoi(m[2]),
+			Column: atoi(m[3]), Message: m[4]})
+	}
+	return diagnostics
+}
+
+// Parse error at 0:3,4 (followed by the source line and a caret, and then
+// "ERROR: <message>"). The column is counted from 0, and older versions put
+// the message on the line after the location instead.
+var uglifyRegex = regexp.MustCompile(`^Parse error at (.*):(\d+),(\d+)`)
+
+func parseUglify(stderr string) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0, 1)
+	lines := strings.Split(stderr, "\n")
+	for i, line := range lines {
+		m := uglifyRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{File: m[1], Line: atoi(m[2]), Column: atoi(m[3]) + 1}
+		for _, next := range lines[i+1:] {
+			if uglifyRegex.MatchString(next) {
+				break
+			}
+			if strings.HasPrefix(next, "ERROR: ") {
+				d.Message = strings.TrimPrefix(next, "ERROR: ")
+				break
+			}
+		}
+		if d.Message == "" && i+1 < len(lines) {
+			d.Message = strings.TrimSpace(lines[i+1])
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+// WARNING: Invalid property name 'colr' at 3:4. Ignoring.
+//
+// The column is counted from 0.
+var cleanCssRegex = regexp.MustCompile(`(?m)^(?:WARNING|ERROR): (.*?) at (?:(.+?):)?(\d+):(\d+)\.?(.*)$`)
+
+func parseCleanCss(stderr string) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0, 1)
+	for _, m := range cleanCssRegex.FindAllStringSubmatch(stderr, -1) {
+		message := m[1]
+		if rest := strings.TrimSpace(m[5]); rest != "" {
+			message += ". " + rest
+		}
+		diagnostics = append(diagnostics, Diagnostic{File: m[2], Line: atoi(m[3]),
+			Column: atoi(m[4]) + 1, Message: message})
+	}
+	return diagnostics
+}
+
+// file:line:column: message, which is what most compilers print
+var gccRegex = regexp.MustCompile(`(?m)^([^:\s][^:]*):(\d+):(?:(\d+):)? (.*)$`)
+
+func parseGcc(stderr string) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0, 1)
+	for _, m := range gccRegex.FindAllStringSubmatch(stderr, -1) {
+		diagnostics = append(diagnostics, Diagnostic{File: m[1], Line: atoi(m[2]),
+			Column: atoi(m[3]), Message: m[4]})
+	}
+	return diagnostics
+}
+
+// diagnose parses the stderr of a program that ran on a File. Diagnostics
+// in the File itself are given its name and source line. Programs that
+// read from stdin call it something like "-", "[stdin]", or "0", so those
+// are treated as the File too.
+func diagnose(parser diagnosticParser, stderr []byte, file File) []Diagnostic {
+	diagnostics := parser(stripAnsi(string(stderr)))
+	var lines []string
+	for i, d := range diagnostics {
+		switch d.File {
+		case "", "-", "0", "[stdin]", "stdin", "<stdin>", file.Fullpath(),
+			filepath.Base(file.Name()):
+		default:
+			continue
+		}
+		d.File = file.Name()
+		if lines == nil {
+			lines = strings.Split(string(file.Data()), "\n")
+		}
+		if d.Line > 0 && d.Line <= len(lines) {
+			d.Source = strings.TrimRight(lines[d.Line-1], "\r")
+		}
+		diagnostics[i] = d
+	}
+	return diagnostics
+}
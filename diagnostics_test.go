@@ -0,0 +1,136 @@
+package pike
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// Stderr in the formats printed by each tool. Of the column numbers, lessc
+// and coffee count from 1, while uglifyjs and cleancss count from 0.
+var parserTests = []struct {
+	name   string
+	parser diagnosticParser
+	stderr string
+	want   []Diagnostic
+}{
+	{
+		name:   "lessc",
+		parser: parseLessc,
+		stderr: "\x1b[31mParseError: Unrecognised input. Possibly missing something\x1b[39m" +
+			"\x1b[31m in \x1b[39m\x1b[1m\x1b[31m/app/src/a.less\x1b[39m\x1b[22m\x1b[31m" +
+			" on line 3, column 5:\x1b[39m\n" +
+			"\x1b[90m2 a {\x1b[39m\n3   color red\n\x1b[90m4 }\x1b[39m\n",
+		want: []Diagnostic{{File: "/app/src/a.less", Line: 3, Column: 5,
+			Message: "Unrecognised input. Possibly missing something"}},
+	},
+	{
+		name:   "lessc name error",
+		parser: parseLessc,
+		stderr: "NameError: variable @colour is undefined in - on line 1, column 12:\n" +
+			"1 a { color: @colour; }\n",
+		want: []Diagnostic{{File: "-", Line: 1, Column: 12,
+			Message: "variable @colour is undefined"}},
+	},
+	{
+		name:   "coffee",
+		parser: parseCoffee,
+		stderr: "[stdin]:2:5: error: unexpected ;\n  x = ;\n    ^\n",
+		want: []Diagnostic{{File: "[stdin]", Line: 2, Column: 5,
+			Message: "unexpected ;"}},
+	},
+	{
+		name:   "uglifyjs",
+		parser: parseUglify,
+		stderr: "Parse error at 0:1,4\nx = ;\n    ^\n" +
+			"ERROR: Unexpected token: punc «;»\n" +
+			"    at JS_Parse_Error.get (eval at <anonymous> (/usr/lib/node_modules/uglify-js/tools/node.js:18:1), <anonymous>:71:23)\n",
+		want: []Diagnostic{{File: "0", Line: 1, Column: 5,
+			Message: "Unexpected token: punc «;»"}},
+	},
+	{
+		name:   "uglifyjs 2",
+		parser: parseUglify,
+		stderr: "Parse error at /app/src/a.js:7,0\nUnexpected token eof «undefined», expected punc «,»\n",
+		want: []Diagnostic{{File: "/app/src/a.js", Line: 7, Column: 1,
+			Message: "Unexpected token eof «undefined», expected punc «,»"}},
+	},
+	{
+		name:   "cleancss",
+		parser: parseCleanCss,
+		stderr: "WARNING: Invalid property name 'colr' at 1:4. Ignoring.\n" +
+			"WARNING: Missing '}' at /app/src/a.css:9:0.\n",
+		want: []Diagnostic{
+			{Line: 1, Column: 5, Message: "Invalid property name 'colr'. Ignoring."},
+			{File: "/app/src/a.css", Line: 9, Column: 1, Message: "Missing '}'"},
+		},
+	},
+	{
+		name:   "gcc",
+		parser: parseGcc,
+		stderr: "a.c: In function 'main':\n" +
+			"a.c:3:12: error: expected ';' before '}' token\n" +
+			"    3 |   return 0\n      |            ^\n" +
+			"a.h:10: warning: no newline at end of file\n",
+		want: []Diagnostic{
+			{File: "a.c", Line: 3, Column: 12, Message: "error: expected ';' before '}' token"},
+			{File: "a.h", Line: 10, Message: "warning: no newline at end of file"},
+		},
+	},
+}
+
+func TestParsers(t *testing.T) {
+	for _, test := range parserTests {
+		got := test.parser(stripAnsi(test.stderr))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected\n%#v\ngot\n%#v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestDiagnoseStdin(t *testing.T) {
+	file := NewFile("/app/src", "css/a.less", []byte("a {\n\tcolor red\n}\n"))
+	stderr := "ParseError: Unrecognised input in - on line 2, column 2:\n" +
+		"ParseError: Unrecognised input in /app/src/css/b.less on line 1, column 1:\n"
+	got := diagnose(parseLessc, []byte(stderr), file)
+	want := []Diagnostic{
+		{File: "css/a.less", Line: 2, Column: 2, Message: "Unrecognised input",
+			Source: "\tcolor red"},
+		{File: "/app/src/css/b.less", Line: 1, Column: 1, Message: "Unrecognised input"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected\n%#v\ngot\n%#v", want, got)
+	}
+	if text := got[0].String(); text != "css/a.less:2:2: Unrecognised input\n"+
+		"2 | \tcolor red\n  | \t^" {
+		t.Errorf("Unexpected format %q", text)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	runner := NewFakeRunner()
+	runner.On("lessc", FakeResponse{ExitCode: 1,
+		Stderr: []byte("ParseError: Unrecognised input in - on line 1, column 1:\n")})
+	runner.On("mytool", FakeResponse{ExitCode: 1,
+		Stderr: []byte("retrying: 1: connection refused\n")})
+	ctx := WithCommandRunner(context.Background(), runner)
+	file := NewFile("src", "a.less", []byte("a {"))
+
+	for _, args := range [][]string{{"lessc", "-"}, {"mytool"}} {
+		tool := mustExecRunner(ExecConfig{Args: args, Retries: 2, RetryDelay: 1})
+		_, err := tool.runFile(ctx, file, 1)
+		toolErr, ok := err.(*ToolError)
+		if !ok {
+			t.Fatalf("%s: expected a ToolError, got %#v", args[0], err)
+		}
+		// A syntax error won't be fixed by running lessc again, but the
+		// "gcc" fallback can't tell an error in the file from any other
+		want := 1
+		if args[0] == "mytool" {
+			want = 3
+		}
+		if toolErr.Attempts != want {
+			t.Errorf("%s: expected %d attempts, got %d", args[0], want, toolErr.Attempts)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package pike
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NodeError is an error that was reported by a Node while a Graph was
+// running. File is the name of the File that could not be processed, and
+// will be "" if the error was not caused by any one File.
+type NodeError struct {
+	Graph string
+	Node  string
+	File  string
+	Err   error
+}
+
+func (e *NodeError) Error() string {
+	parts := make([]string, 0, 4)
+	for _, part := range []string{e.Graph, e.Node, e.File} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	parts = append(parts, e.Err.Error())
+	return strings.Join(parts, ": ")
+}
+
+// PanicError is the error for a Node that panicked. Stack is the stack
+// trace from where it panicked.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+}
+
+// TimeoutError is the error for an external program that was killed because
+// it ran for longer than its timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+	Elapsed time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("killed after %s (the timeout is %s)",
+		e.Elapsed.Round(time.Millisecond), e.Timeout)
+}
+
+// FileError creates an error for a File that a Node failed to process. The
+// Graph will fill in the name of the Node.
+func FileError(file File, err error) error {
+	return &NodeError{File: file.Name(), Err: err}
+}
+
+// Errors is a list of all the errors that were reported while running one
+// or more Graphs.
+type Errors []*NodeError
+
+func (errs Errors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Add appends an error to the list. Errors and *NodeErrors are added as-is,
+// any other error is wrapped in a NodeError.
+func (errs *Errors) Add(err error) {
+	switch err := err.(type) {
+	case nil:
+	case Errors:
+		*errs = append(*errs, err...)
+	case *NodeError:
+		*errs = append(*errs, err)
+	default:
+		*errs = append(*errs, &NodeError{Err: err})
+	}
+}
+
+// Err returns nil if the list is empty, or the list itself otherwise. Use
+// this when returning the list as an error.
+func (errs Errors) Err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
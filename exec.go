@@ -0,0 +1,522 @@
+package pike
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/stevearc/pike/plog"
+)
+
+// ExecDir is where Exec runs its command.
+type ExecDir string
+
+const (
+	// Run in the directory of the File, so that relative imports work
+	ExecDirFile ExecDir = "file"
+	// Run in the working directory of pike
+	ExecDirCurrent ExecDir = "current"
+	// Run in a new temporary directory, which is removed afterwards
+	ExecDirTemp ExecDir = "temp"
+)
+
+// ExecPort is an extra file that the command writes, which Exec will send
+// on its own port.
+type ExecPort struct {
+	Name string `json:"name"`
+	// The path of the file, relative to the directory that the command ran
+	// in. This is a template, like the arguments.
+	File string `json:"file"`
+}
+
+// ExecConfig describes how Exec runs a command-line tool on each File.
+//
+// Args is the command and its arguments. Each one is a go template, with
+// these variables for the example File "src/myapp.js" under "/app":
+//
+//	Fullname: src/myapp.js
+//	Dir     : src
+//	Name    : myapp.js
+//	Barename: myapp
+//	Ext     : .js
+//	Root    : /app
+//	Path    : /app/src/myapp.js
+//	Temp    : the temporary directory, if there is one
+//	Input   : the path of the copy of the File, if using TempFile
+//
+// Normally the File is sent to the command on stdin, and stdout becomes the
+// new contents of the File. If TempFile is true, the File is instead
+// written to a temporary directory for tools that can only read from disk.
+// If Output is set, the new contents are read from that file (relative to
+// the directory that the command ran in) instead of from stdout.
+//
+// The command runs in the directory of the File unless Dir says otherwise.
+// With TempFile, it runs in the temporary directory by default.
+type ExecConfig struct {
+	Args     []string `json:"args"`
+	TempFile bool     `json:"temp_file"`
+	Output   string   `json:"output"`
+	Dir      ExecDir  `json:"dir"`
+	// If not "", the extension for the new File (e.g. ".css")
+	Ext string `json:"ext"`
+	// Extra files to collect. If there are any, or if SourcePort is set,
+	// the Node has named ports: Port (default "out") for the new File,
+	// then one for each of Extra, then SourcePort.
+	Port  string     `json:"port"`
+	Extra []ExecPort `json:"extra"`
+	// If not "", the name of a port that gets the original File
+	SourcePort string `json:"source_port"`
+	// How to install the program, and the lowest version that works (see
+	// Tool). These are checked before the Graph runs.
+	Install    string `json:"install"`
+	MinVersion string `json:"min_version"`
+	// How to parse the errors that the program writes to stderr: "lessc",
+	// "coffee", "uglifyjs", "cleancss", or "gcc" (file:line:column:
+	// message). By default this is picked by the name of the program, or
+	// "gcc" if none match.
+	Diagnostics string `json:"diagnostics"`
+	// How long the program may run on one File before it is killed, along
+	// with any processes that it started. 0 uses the default from
+	// SetToolTimeout, and a negative timeout means no limit.
+	Timeout time.Duration `json:"-"`
+	// How many more times to run the program if it fails, and how long to
+	// wait before the first retry. The wait doubles after each retry. A
+	// program that exits with an error in the File itself (such as a syntax
+	// error) is not retried, unless its Diagnostics are the "gcc" fallback.
+	// 0 uses the defaults from SetToolRetries, and negative Retries means
+	// none.
+	Retries    int           `json:"retries"`
+	RetryDelay time.Duration `json:"-"`
+}
+
+var toolConfig = struct {
+	lock       sync.Mutex
+	Timeout    time.Duration
+	Retries    int
+	RetryDelay time.Duration
+	// If tools run in their own process groups
+	ProcessGroups bool
+}{
+	RetryDelay: time.Second,
+}
+
+// SetToolTimeout sets the timeout for Exec Nodes that don't have their own
+// (including Less, Coffee, Uglify, and CleanCss). A program that runs for
+// longer than this on one File is killed, along with any processes it
+// started, and the File fails with a TimeoutError. A timeout of 0 (the
+// default) means no limit.
+func SetToolTimeout(timeout time.Duration) {
+	toolConfig.lock.Lock()
+	defer toolConfig.lock.Unlock()
+	toolConfig.Timeout = timeout
+}
+
+// SetToolRetries sets how many times Exec Nodes that don't have their own
+// retry policy will run a program again when it fails, and how long they
+// wait before the first retry. The default is no retries.
+func SetToolRetries(retries int, delay time.Duration) {
+	toolConfig.lock.Lock()
+	defer toolConfig.lock.Unlock()
+	toolConfig.Retries = retries
+	toolConfig.RetryDelay = delay
+}
+
+// SetToolProcessGroups sets whether OsCommandRunner starts each tool in its
+// own process group. With process groups, a tool that is killed because of
+// a timeout or a cancelled run is killed along with any processes it started
+// (e.g. the node process behind an npm tool's wrapper script). But the tools
+// are no longer in the terminal's process group, so Ctrl-C doesn't reach
+// them, and they are only stopped if Ctrl-C cancels the context of the run.
+// Only enable this if you do that. Start does, and enables it. By default
+// only the tool itself is killed.
+func SetToolProcessGroups(enabled bool) {
+	toolConfig.lock.Lock()
+	defer toolConfig.lock.Unlock()
+	toolConfig.ProcessGroups = enabled
+}
+
+// execVars are the variables for the templates in an ExecConfig.
+type execVars struct {
+	Fullname string
+	Dir      string
+	Name     string
+	Barename string
+	Ext      string
+	Root     string
+	Path     string
+	Temp     string
+	Input    string
+}
+
+func newExecVars(file File) *execVars {
+	base := filepath.Base(file.Name())
+	ext := filepath.Ext(base)
+	return &execVars{
+		Fullname: file.Name(),
+		Dir:      filepath.Dir(file.Name()),
+		Name:     base,
+		Barename: base[:len(base)-len(ext)],
+		Ext:      ext,
+		Root:     file.Root(),
+		Path:     file.Fullpath(),
+	}
+}
+
+// execRunner holds an ExecConfig with its templates parsed.
+type execRunner struct {
+	config *ExecConfig
+	args   []*template.Template
+	output *template.Template
+	extra  []*template.Template
+	parser diagnosticParser
+	// If the parser is the "gcc" fallback, which can match lines that
+	// aren't errors
+	fallback bool
+}
+
+func newExecRunner(config ExecConfig) (*execRunner, error) {
+	if len(config.Args) == 0 {
+		return nil, errors.New("Exec needs a command to run")
+	}
+	switch config.Dir {
+	case "", ExecDirFile, ExecDirCurrent, ExecDirTemp:
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown Exec dir %q", config.Dir))
+	}
+	self := &execRunner{config: &config}
+	if config.Diagnostics != "" {
+		self.parser = diagnosticParsers[config.Diagnostics]
+		if self.parser == nil {
+			return nil, errors.New(fmt.Sprintf("Unknown Exec diagnostics %q",
+				config.Diagnostics))
+		}
+	} else if parser, ok := diagnosticParsers[filepath.Base(config.Args[0])]; ok {
+		self.parser = parser
+	} else {
+		self.parser = parseGcc
+		self.fallback = true
+	}
+	parse := func(text string) (*template.Template, error) {
+		return template.New("exec").Option("missingkey=error").Parse(text)
+	}
+	for _, arg := range config.Args {
+		tmpl, err := parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		self.args = append(self.args, tmpl)
+	}
+	if config.Output != "" {
+		tmpl, err := parse(config.Output)
+		if err != nil {
+			return nil, err
+		}
+		self.output = tmpl
+	}
+	for _, extra := range config.Extra {
+		tmpl, err := parse(extra.File)
+		if err != nil {
+			return nil, err
+		}
+		self.extra = append(self.extra, tmpl)
+	}
+	return self, nil
+}
+
+func expand(tmpl *template.Template, vars *execVars) (string, error) {
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, vars); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// ports returns the names of the Node's outputs, or nil if it only has
+// one unnamed output.
+func (self *execRunner) ports() []string {
+	if len(self.config.Extra) == 0 && self.config.SourcePort == "" {
+		return nil
+	}
+	port := self.config.Port
+	if port == "" {
+		port = "out"
+	}
+	ports := []string{port}
+	for _, extra := range self.config.Extra {
+		ports = append(ports, extra.Name)
+	}
+	if self.config.SourcePort != "" {
+		ports = append(ports, self.config.SourcePort)
+	}
+	return ports
+}
+
+// run processes every File from 'in'. It does not close the outputs.
+func (self *execRunner) run(ctx context.Context, in chan File, out []chan File) error {
+	var errs Errors
+	for file := range in {
+		results, err := self.runFile(ctx, file, len(out))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			errs.Add(FileError(file, err))
+			continue
+		}
+		for i, result := range results {
+			out[i] <- result
+		}
+	}
+	return errs.Err()
+}
+
+// runFile runs the command on one File, and returns the Files for each of
+// the first 'numOut' ports.
+func (self *execRunner) runFile(ctx context.Context, file File, numOut int) ([]File, error) {
+	config := self.config
+	vars := newExecVars(file)
+	dir := filepath.Dir(file.Fullpath())
+	if config.Dir == ExecDirCurrent {
+		dir = ""
+	}
+	if config.TempFile || config.Dir == ExecDirTemp {
+		tempdir, err := ioutil.TempDir("", "pike")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tempdir)
+		vars.Temp = tempdir
+		if config.Dir == ExecDirTemp || config.Dir == "" {
+			dir = tempdir
+		}
+		if config.TempFile {
+			vars.Input = filepath.Join(tempdir, vars.Name)
+			if err := ioutil.WriteFile(vars.Input, file.Data(), 0600); err != nil {
+				return nil, err
+			}
+		}
+	}
+	args := make([]string, len(self.args))
+	for i, tmpl := range self.args {
+		arg, err := expand(tmpl, vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+
+	cmd := &Command{Name: args[0], Args: args[1:], Dir: dir}
+	if !config.TempFile {
+		cmd.Stdin = file.Data()
+	}
+	tool := filepath.Base(args[0])
+	result, attempts, err := self.invoke(ctx, cmd, file)
+	if err != nil {
+		toolErr := &ToolError{Tool: tool, Err: err, Attempts: attempts}
+		if result != nil {
+			toolErr.Stderr = result.Stderr
+			toolErr.Diagnostics = diagnose(self.parser, result.Stderr, file)
+		}
+		return nil, toolErr
+	}
+	if len(bytes.TrimSpace(result.Stderr)) > 0 {
+		lines := describeStderr(diagnose(self.parser, result.Stderr, file), result.Stderr)
+		plog.Warn("%s: warnings from %s:\n%s", file.Name(), tool,
+			strings.Join(lines, "\n"))
+	}
+	newData := result.Stdout
+
+	// Paths that the command wrote are relative to where it ran
+	readOutput := func(tmpl *template.Template) (string, []byte, error) {
+		path, err := expand(tmpl, vars)
+		if err != nil {
+			return "", nil, err
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := ioutil.ReadFile(path)
+		return path, data, err
+	}
+	if self.output != nil {
+		if _, newData, err = readOutput(self.output); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]File, 0, numOut)
+	sourceIndex := len(self.ports()) - 1
+	newFile := file
+	if config.SourcePort != "" && sourceIndex < numOut {
+		// The original File is sent on as well, so leave it alone
+		newFile = NewFile(file.Root(), file.Name(), nil)
+	}
+	newFile.SetData(newData)
+	if config.Ext != "" {
+		newFile.SetExt(config.Ext)
+	}
+	results = append(results, newFile)
+	for i, tmpl := range self.extra {
+		if i+1 >= numOut {
+			break
+		}
+		path, data, err := readOutput(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Join(vars.Dir, filepath.Base(path))
+		results = append(results, NewFile(file.Root(), name, data))
+	}
+	if config.SourcePort != "" && sourceIndex < numOut {
+		results = append(results, file)
+	}
+	return results, nil
+}
+
+// invoke runs a command on a File, retrying if it fails as many times as
+// the config allows. It returns the result of the last attempt and the
+// number of attempts.
+func (self *execRunner) invoke(ctx context.Context, cmd *Command, file File) (*CommandResult, int, error) {
+	timeout, retries, delay := self.config.Timeout, self.config.Retries, self.config.RetryDelay
+	toolConfig.lock.Lock()
+	if timeout == 0 {
+		timeout = toolConfig.Timeout
+	}
+	if retries == 0 {
+		retries = toolConfig.Retries
+	}
+	if delay == 0 {
+		delay = toolConfig.RetryDelay
+	}
+	toolConfig.lock.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		result, err := self.invokeOnce(ctx, cmd, file, timeout)
+		if err == nil || attempt > retries || ctx.Err() != nil {
+			return result, attempt, err
+		}
+		if self.rejected(result, err, file) {
+			return result, attempt, err
+		}
+		plog.Warn("%s: %s failed (%v), trying again in %s", file.Name(),
+			filepath.Base(cmd.Name), err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return result, attempt, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// rejected returns true if a program failed because of a problem in the
+// File, such as a syntax error, which running it again won't fix. That is
+// when it exited with an error and reported a Diagnostic in the File. The
+// "gcc" fallback isn't trusted for this, since any "x:1: text" line on
+// stderr would match.
+func (self *execRunner) rejected(result *CommandResult, err error, file File) bool {
+	if _, ok := err.(*ExitError); !ok || result == nil || self.fallback {
+		return false
+	}
+	for _, d := range diagnose(self.parser, result.Stderr, file) {
+		if d.File == file.Name() {
+			return true
+		}
+	}
+	return false
+}
+
+// invokeOnce runs a command on a File, and kills it if it runs for longer
+// than 'timeout'.
+func (self *execRunner) invokeOnce(ctx context.Context, cmd *Command, file File,
+	timeout time.Duration) (*CommandResult, error) {
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	end := traceCommand(ctx, cmd, file)
+	exited := statsCollectorFrom(ctx).startCommand()
+	result, err := commandRunnerFrom(ctx).Run(runCtx, cmd)
+	exited()
+	end()
+	if err != nil && ctx.Err() == nil && runCtx.Err() == context.DeadlineExceeded {
+		return result, &TimeoutError{Timeout: timeout, Elapsed: time.Since(start)}
+	}
+	return result, err
+}
+
+// mustExecRunner is newExecRunner for configs that are known to be valid.
+func mustExecRunner(config ExecConfig) *execRunner {
+	runner, err := newExecRunner(config)
+	if err != nil {
+		panic(err)
+	}
+	return runner
+}
+
+// Exec creates a Node that runs a command-line tool on each File. See
+// ExecConfig for the options. If the config is invalid, the Graph will
+// report it when it is run.
+func Exec(name string, config ExecConfig) *Node {
+	runner, err := newExecRunner(config)
+	if err != nil {
+		node := NewFuncNode(name, func(ctx context.Context, in, out chan File) error {
+			return err
+		})
+		node.problems = append(node.problems, fmt.Sprintf("%s: %v", name, err))
+		return node
+	}
+	var node *Node
+	if ports := runner.ports(); ports == nil {
+		node = NewFuncNode(name, func(ctx context.Context, in, out chan File) error {
+			return runner.run(ctx, in, []chan File{out})
+		})
+	} else {
+		node = NewPortNode(name, 1, 1, 1, ports, FxnRunnable(runner.runPorts))
+	}
+	node.Tools = runner.tools()
+	node.Options = runner.options()
+	return node
+}
+
+// options describes the config for Node.Options. The timeout and retries
+// are left out, since they don't change the output.
+func (self *execRunner) options() string {
+	config := *self.config
+	config.Timeout, config.Retries, config.RetryDelay = 0, 0, 0
+	data, _ := json.Marshal(config)
+	return string(data)
+}
+
+// runPorts is the Runnable for an Exec Node with named ports.
+func (self *execRunner) runPorts(ctx context.Context, in, out []chan File) error {
+	err := self.run(ctx, in[0], out)
+	for _, c := range out {
+		close(c)
+	}
+	for _ = range in[0] {
+	}
+	return err
+}
+
+// tools returns the program that the Node runs, unless it is a template.
+func (self *execRunner) tools() []Tool {
+	name := self.config.Args[0]
+	if strings.Contains(name, "{{") {
+		return nil
+	}
+	return []Tool{{Name: name, Install: self.config.Install,
+		MinVersion: self.config.MinVersion}}
+}
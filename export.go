@@ -0,0 +1,142 @@
+package pike
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Mermaid returns a Mermaid flowchart of the Graph, which can be embedded
+// in Markdown. Like Dot, Graphs that are used as Nodes are drawn as
+// subgraphs with all of their own Nodes, and the Node IDs are the same
+// every time.
+func (self *Graph) Mermaid() string {
+	ids := make(map[*Node]string)
+	self.nodeIds("n", ids)
+	lines := []string{"flowchart TD"}
+	lines = self.mermaidLines("  ", ids, lines)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// mermaidText quotes a label for Mermaid.
+func mermaidText(text string) string {
+	return "\"" + strings.Replace(text, "\"", "#quot;", -1) + "\""
+}
+
+func (self *Graph) mermaidLines(indent string, ids map[*Node]string, lines []string) []string {
+	for _, n := range self.nodes {
+		if runner, ok := n.Runner.(*GraphRunnable); ok {
+			lines = append(lines, fmt.Sprintf("%ssubgraph %s [%s]", indent, ids[n],
+				mermaidText(runner.Graph.Name)))
+			lines = runner.Graph.mermaidLines(indent+"  ", ids, lines)
+			lines = append(lines, indent+"end")
+		} else {
+			lines = append(lines, fmt.Sprintf("%s%s[%s]", indent, ids[n], mermaidText(n.Name)))
+		}
+	}
+	for _, n := range self.nodes {
+		for i, next := range n.Outputs {
+			// Ports that were skipped over have no edge
+			if next == nil {
+				continue
+			}
+			from, okFrom := ids[exitNode(n)]
+			to, okTo := ids[entryNode(next)]
+			if !okFrom || !okTo {
+				continue
+			}
+			if label := portLabel(n, i); label != "" {
+				lines = append(lines, fmt.Sprintf("%s%s -->|%s| %s", indent, from,
+					mermaidText(label), to))
+			} else {
+				lines = append(lines, fmt.Sprintf("%s%s --> %s", indent, from, to))
+			}
+		}
+	}
+	return lines
+}
+
+// graphJson is the JSON representation of a Graph's structure.
+type graphJson struct {
+	Name   string      `json:"name"`
+	Source string      `json:"source,omitempty"`
+	Sink   string      `json:"sink,omitempty"`
+	Nodes  []*nodeJson `json:"nodes"`
+	Edges  []*edgeJson `json:"edges"`
+}
+
+type nodeJson struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Runner     string     `json:"runner"`
+	MinInputs  int        `json:"min_inputs"`
+	MaxInputs  int        `json:"max_inputs"`
+	MinOutputs int        `json:"min_outputs"`
+	MaxOutputs int        `json:"max_outputs"`
+	Ports      []string   `json:"ports,omitempty"`
+	Graph      *graphJson `json:"graph,omitempty"`
+}
+
+type edgeJson struct {
+	From string `json:"from"`
+	// The index of the output on 'from', and its name if it has one
+	Port     int    `json:"port"`
+	PortName string `json:"port_name,omitempty"`
+	To       string `json:"to"`
+	// The index of the input on 'to'
+	Input int `json:"input"`
+}
+
+// MarshalJSON describes the structure of the Graph: its Nodes, with their
+// limits on inputs and outputs, and the edges between them. Graphs that
+// are used as Nodes are included in the Node as "graph". Max inputs and
+// outputs are -1 if there is no limit. The Node IDs are the same ones used
+// by Dot and Mermaid.
+func (self *Graph) MarshalJSON() ([]byte, error) {
+	ids := make(map[*Node]string)
+	self.nodeIds("n", ids)
+	return json.Marshal(self.toJson(ids))
+}
+
+func (self *Graph) toJson(ids map[*Node]string) *graphJson {
+	result := &graphJson{
+		Name:  self.Name,
+		Nodes: make([]*nodeJson, 0, len(self.nodes)),
+		Edges: make([]*edgeJson, 0, len(self.nodes)),
+	}
+	if self.Source != nil {
+		result.Source = ids[self.Source]
+	}
+	if self.Sink != nil {
+		result.Sink = ids[self.Sink]
+	}
+	for _, n := range self.nodes {
+		node := &nodeJson{
+			Id:         ids[n],
+			Name:       n.Name,
+			Runner:     fmt.Sprintf("%T", n.Runner),
+			MinInputs:  n.MinInputs,
+			MaxInputs:  n.MaxInputs,
+			MinOutputs: n.MinOutputs,
+			MaxOutputs: n.MaxOutputs,
+			Ports:      n.OutputPorts,
+		}
+		if runner, ok := n.Runner.(*GraphRunnable); ok {
+			node.Graph = runner.Graph.toJson(ids)
+		}
+		result.Nodes = append(result.Nodes, node)
+	}
+	for _, edge := range self.Edges() {
+		if _, ok := ids[edge.To]; !ok {
+			continue
+		}
+		result.Edges = append(result.Edges, &edgeJson{
+			From:     ids[edge.From],
+			Port:     edge.Port,
+			PortName: edge.PortName(),
+			To:       ids[edge.To],
+			Input:    edge.Input,
+		})
+	}
+	return result
+}
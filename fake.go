@@ -0,0 +1,124 @@
+package pike
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FakeResponse is what a FakeRunner returns for a Command.
+type FakeResponse struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+	// Files to write before returning, for programs that write their
+	// output to disk. Paths are relative to the directory of the Command.
+	Files map[string][]byte
+	// How long the program takes to run, for testing timeouts. If the
+	// context is cancelled first, Run returns its error.
+	Delay time.Duration
+}
+
+// FakeRunner is a CommandRunner for tests. It doesn't run anything: it
+// records each Command and replies with the response set for the program
+// by On or OnFunc. Commands for any other program fail, and LookPath won't
+// find them. It is safe to use from several Nodes at once.
+//
+//	runner := pike.NewFakeRunner()
+//	runner.OnFunc("lessc", func(cmd *pike.Command) *pike.FakeResponse {
+//	    return &pike.FakeResponse{Stdout: cmd.Stdin}
+//	})
+//	err := pike.RunAllContext(pike.WithCommandRunner(ctx, runner), graphs)
+type FakeRunner struct {
+	lock      sync.Mutex
+	responses map[string]func(cmd *Command) *FakeResponse
+	calls     []Command
+}
+
+// NewFakeRunner creates a FakeRunner with no responses.
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{
+		responses: make(map[string]func(cmd *Command) *FakeResponse),
+		calls:     make([]Command, 0, 10),
+	}
+}
+
+// On sets the response for every Command that runs 'program'. The program
+// is matched by name, so "lessc" also matches "/usr/bin/lessc".
+func (self *FakeRunner) On(program string, response FakeResponse) {
+	self.OnFunc(program, func(cmd *Command) *FakeResponse {
+		return &response
+	})
+}
+
+// OnFunc is like On, but calls a function to make the response for each
+// Command.
+func (self *FakeRunner) OnFunc(program string, respond func(cmd *Command) *FakeResponse) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.responses[program] = respond
+}
+
+// Calls returns every Command that has been run, in order.
+func (self *FakeRunner) Calls() []Command {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]Command{}, self.calls...)
+}
+
+// LookPath finds any program that has a response.
+func (self *FakeRunner) LookPath(name string) (string, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.responses[filepath.Base(name)] == nil {
+		return "", errors.New(fmt.Sprintf("No fake response for %q", name))
+	}
+	return name, nil
+}
+
+func (self *FakeRunner) Run(ctx context.Context, cmd *Command) (*CommandResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	call := *cmd
+	call.Args = append([]string{}, cmd.Args...)
+	if cmd.Stdin != nil {
+		call.Stdin = append([]byte{}, cmd.Stdin...)
+	}
+	self.lock.Lock()
+	self.calls = append(self.calls, call)
+	respond := self.responses[filepath.Base(cmd.Name)]
+	self.lock.Unlock()
+	if respond == nil {
+		return nil, errors.New(fmt.Sprintf("No fake response for %q", cmd.Name))
+	}
+
+	response := respond(&call)
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	for name, data := range response.Files {
+		path := filepath.Join(cmd.Dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	result := &CommandResult{Stdout: response.Stdout, Stderr: response.Stderr,
+		ExitCode: response.ExitCode}
+	if result.ExitCode != 0 {
+		return result, &ExitError{result.ExitCode}
+	}
+	return result, nil
+}
@@ -0,0 +1,127 @@
+package pike
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFakeRunnerStdout(t *testing.T) {
+	runner := NewFakeRunner()
+	runner.OnFunc("lessc", func(cmd *Command) *FakeResponse {
+		return &FakeResponse{Stdout: append([]byte("/* compiled */\n"), cmd.Stdin...)}
+	})
+	ctx := WithCommandRunner(context.Background(), runner)
+	tool := mustExecRunner(ExecConfig{Args: []string{"/usr/bin/lessc", "-"}, Ext: ".css"})
+
+	files, err := tool.runFile(ctx, NewFile("src", "a.less", []byte("a {}")), 1)
+	if err != nil {
+		t.Fatalf("runFile failed: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 file, got %d", len(files))
+	}
+	if files[0].Name() != "a.css" {
+		t.Errorf("Expected output named a.css, got %q", files[0].Name())
+	}
+	if string(files[0].Data()) != "/* compiled */\na {}" {
+		t.Errorf("Unexpected output %q", files[0].Data())
+	}
+	calls := runner.Calls()
+	if len(calls) != 1 || calls[0].Name != "/usr/bin/lessc" || string(calls[0].Stdin) != "a {}" {
+		t.Errorf("Unexpected calls %+v", calls)
+	}
+}
+
+func TestFakeRunnerExitCode(t *testing.T) {
+	runner := NewFakeRunner()
+	runner.On("cleancss", FakeResponse{ExitCode: 2, Stderr: []byte("bad input\n")})
+	ctx := WithCommandRunner(context.Background(), runner)
+	tool := mustExecRunner(ExecConfig{Args: []string{"cleancss"}})
+
+	_, err := tool.runFile(ctx, NewFile("src", "a.css", []byte("a {}")), 1)
+	toolErr, ok := err.(*ToolError)
+	if !ok {
+		t.Fatalf("Expected a ToolError, got %#v", err)
+	}
+	if exitErr, ok := toolErr.Err.(*ExitError); !ok || exitErr.Code != 2 {
+		t.Errorf("Expected exit status 2, got %v", toolErr.Err)
+	}
+	if string(toolErr.Stderr) != "bad input\n" {
+		t.Errorf("Unexpected stderr %q", toolErr.Stderr)
+	}
+	if toolErr.Attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", toolErr.Attempts)
+	}
+}
+
+func TestFakeRunnerDelay(t *testing.T) {
+	runner := NewFakeRunner()
+	runner.On("uglifyjs", FakeResponse{Delay: time.Minute})
+	ctx := WithCommandRunner(context.Background(), runner)
+	tool := mustExecRunner(ExecConfig{
+		Args:       []string{"uglifyjs"},
+		Timeout:    10 * time.Millisecond,
+		Retries:    1,
+		RetryDelay: time.Millisecond,
+	})
+
+	start := time.Now()
+	_, err := tool.runFile(ctx, NewFile("src", "a.js", []byte("x")), 1)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("The timeout did not stop the command (took %s)", elapsed)
+	}
+	toolErr, ok := err.(*ToolError)
+	if !ok {
+		t.Fatalf("Expected a ToolError, got %#v", err)
+	}
+	timeoutErr, ok := toolErr.Err.(*TimeoutError)
+	if !ok {
+		t.Fatalf("Expected a TimeoutError, got %#v", toolErr.Err)
+	}
+	if timeoutErr.Timeout != 10*time.Millisecond {
+		t.Errorf("Expected a 10ms timeout, got %s", timeoutErr.Timeout)
+	}
+	if toolErr.Attempts != 2 || len(runner.Calls()) != 2 {
+		t.Errorf("Expected 2 attempts, got %d (%d calls)", toolErr.Attempts,
+			len(runner.Calls()))
+	}
+}
+
+func TestToolVersionUsesRunner(t *testing.T) {
+	runner := NewFakeRunner()
+	runner.On("lessc", FakeResponse{Stdout: []byte("lessc 3.9.0 (Less Compiler)\n")})
+	ctx := WithCommandRunner(context.Background(), runner)
+
+	if version := ToolVersion(ctx, "lessc"); version != "lessc 3.9.0 (Less Compiler)" {
+		t.Errorf("Unexpected version %q", version)
+	}
+	calls := runner.Calls()
+	if len(calls) != 1 || len(calls[0].Args) != 1 || calls[0].Args[0] != "--version" {
+		t.Errorf("Unexpected calls %+v", calls)
+	}
+}
+
+func TestRunningToolIsNotIdle(t *testing.T) {
+	ctx, collector := withStatsCollector(context.Background())
+	idle := time.Duration(-1)
+	runner := NewFakeRunner()
+	runner.OnFunc("lessc", func(cmd *Command) *FakeResponse {
+		idle = collector.idle()
+		return &FakeResponse{}
+	})
+	ctx = WithCommandRunner(ctx, runner)
+	atomic.StoreInt64(&collector.lastMove, time.Now().Add(-time.Hour).UnixNano())
+	tool := mustExecRunner(ExecConfig{Args: []string{"lessc", "-"}})
+
+	if _, err := tool.runFile(ctx, NewFile("src", "a.less", nil), 1); err != nil {
+		t.Fatalf("runFile failed: %v", err)
+	}
+	if idle != 0 {
+		t.Errorf("Expected the run not to be idle while lessc ran, got %s", idle)
+	}
+	if after := collector.idle(); after > time.Minute {
+		t.Errorf("Expected the tool exiting to count as progress, idle for %s", after)
+	}
+}
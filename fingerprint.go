@@ -1,6 +1,7 @@
 package pike
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
@@ -10,7 +11,7 @@ import (
 // Fingerprint creates a Node that will add an md5 hash to the name of all
 // files it processes. This is useful for cache busting.
 func Fingerprint() *Node {
-	f := func(in, out chan File) {
+	f := func(ctx context.Context, in, out chan File) error {
 		for file := range in {
 			sum := md5.Sum(file.Data())
 			hash := hex.EncodeToString(sum[:])
@@ -23,6 +24,7 @@ func Fingerprint() *Node {
 			file.SetName(filepath.Join(parent, newname))
 			out <- file
 		}
+		return nil
 	}
 	return NewFuncNode("fingerprint", f)
 }
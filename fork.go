@@ -1,13 +1,16 @@
 package pike
 
-import "runtime"
+import (
+	"context"
+	"runtime"
+)
 
 // LoadBalancer creates a Node that allocates a portion of its files to each
 // of its connected outputs. It will preserve the ordering of files, so the
 // first output gets the first N files, the second output gets the second N
 // files, etc.
 func LoadBalancer() *Node {
-	f := func(in, out []chan File) {
+	f := func(ctx context.Context, in, out []chan File) error {
 		files := make([]File, 0, 20)
 		for file := range in[0] {
 			files = append(files, file)
@@ -27,6 +30,7 @@ func LoadBalancer() *Node {
 				close(out[i])
 			}()
 		}
+		return nil
 	}
 	runner := FxnRunnable(f)
 	return NewNode("load balancer", 1, 1, 1, -1, runner)
@@ -36,7 +40,7 @@ func LoadBalancer() *Node {
 // its files to each of its connected outputs. There are no ordering
 // guarantees.
 func LoadBalancerUnordered() *Node {
-	f := func(in, out []chan File) {
+	f := func(ctx context.Context, in, out []chan File) error {
 		idx := 0
 		for file := range in[0] {
 			out[idx] <- file
@@ -45,6 +49,7 @@ func LoadBalancerUnordered() *Node {
 		for _, o := range out {
 			close(o)
 		}
+		return nil
 	}
 	runner := FxnRunnable(f)
 	return NewNode("load balancer unordered", 1, 1, 1, -1, runner)
@@ -78,6 +83,7 @@ func makeFork(n1, n2, lb *Node, merge func() *Node, count, edges int) *Node {
 	}
 	n1.Pipe(lb)
 	fanIn := FanIn()
+	fanIn.OutputPorts = portSlice(n2.OutputPorts, edges)
 	merges := make([]*Node, edges, edges)
 	for i := 0; i < edges; i++ {
 		merges[i] = merge()
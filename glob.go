@@ -1,13 +1,12 @@
 package pike
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
-
-	"github.com/stevearc/pike/plog"
 )
 
 func remove(stringArr []string, str string) {
@@ -23,38 +22,93 @@ func remove(stringArr []string, str string) {
 // will search recursively under 'root' for any files that match the patterns. The patterns are standard globs, with one exception. If you place a "!" at the beginning of the pattern, it will find all matching files and *remove* them from the existing set of matched files. You can use this, for example, to match all unminified css files:
 //    n := pike.Glob("src", "*.css", "!*.min.css")
 func Glob(root string, patterns ...string) *Node {
-	sourceFunc := func(in, out []chan File) {
-		paths := make([]string, 0, 10)
-		for _, pattern := range patterns {
-			if pattern[0] == '!' {
-				for _, unmatch := range matchRecursive(root, pattern[1:]) {
-					remove(paths, unmatch)
-				}
-			} else {
-				paths = append(paths, matchRecursive(root, pattern)...)
+	runner := &GlobRunnable{root, patterns}
+	node := NewNode(fmt.Sprintf("%s -> %s", root, strings.Join(patterns, ":")), 0, 0, 1, 1, runner)
+	node.Options = fmt.Sprintf("%q %q", root, patterns)
+	return node
+}
+
+// GlobRunnable is the Runnable for Glob nodes. The root and patterns are
+// exposed so that watchers can tell which files a Graph reads.
+type GlobRunnable struct {
+	Root     string
+	Patterns []string
+}
+
+func (self *GlobRunnable) Run(ctx context.Context, in, out []chan File) error {
+	var errs Errors
+	root := self.Root
+	paths := make([]string, 0, 10)
+	for _, pattern := range self.Patterns {
+		if pattern[0] == '!' {
+			for _, unmatch := range matchRecursive(root, pattern[1:], &errs) {
+				remove(paths, unmatch)
 			}
+		} else {
+			paths = append(paths, matchRecursive(root, pattern, &errs)...)
 		}
-		seenPaths := make(map[string]bool)
-		for _, name := range paths {
-			if name == "" || seenPaths[name] {
-				continue
-			}
-			seenPaths[name] = true
-			fullpath := filepath.Join(root, name)
-			data, err := ioutil.ReadFile(fullpath)
-			if err != nil {
-				plog.Error("Error reading file %q", fullpath)
-				continue
+	}
+	seenPaths := make(map[string]bool)
+	for _, name := range paths {
+		if ctx.Err() != nil {
+			close(out[0])
+			return ctx.Err()
+		}
+		if name == "" || seenPaths[name] {
+			continue
+		}
+		seenPaths[name] = true
+		fullpath := filepath.Join(root, name)
+		data, err := ioutil.ReadFile(fullpath)
+		if err != nil {
+			errs.Add(&NodeError{File: name, Err: err})
+			continue
+		}
+		out[0] <- NewFile(root, name, data)
+	}
+	close(out[0])
+	return errs.Err()
+}
+
+// Matches returns true if the file at 'path' would be read by this Glob.
+// The patterns are applied in order, just like when the Glob runs.
+func (self *GlobRunnable) Matches(path string) bool {
+	rel, err := filepath.Rel(self.Root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	matched := false
+	for _, pattern := range self.Patterns {
+		if pattern[0] == '!' {
+			if matchPath(rel, pattern[1:]) {
+				matched = false
 			}
-			out[0] <- NewFile(root, name, data)
+		} else if matchPath(rel, pattern) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPath checks a path relative to the Glob root against a pattern the
+// same way that matchRecursive does.
+func matchPath(rel, pattern string) bool {
+	subRoot, pattern := filepath.Split(pattern)
+	if subRoot != "" {
+		subRoot = filepath.Clean(subRoot)
+		if !strings.HasPrefix(rel, subRoot+string(filepath.Separator)) {
+			return false
 		}
-		close(out[0])
 	}
-	runner := FxnRunnable(sourceFunc)
-	return NewNode(fmt.Sprintf("%s -> %s", root, strings.Join(patterns, ":")), 0, 0, 1, 1, runner)
+	matched, _ := filepath.Match(pattern, filepath.Base(rel))
+	return matched
 }
 
-func matchRecursive(root, pattern string) []string {
+func (self *GlobRunnable) Copy() Runnable {
+	return &GlobRunnable{self.Root, self.Patterns}
+}
+
+func matchRecursive(root, pattern string, errs *Errors) []string {
 	paths := make([]string, 0, 10)
 	fullRoot := root
 	subRoot, pattern := filepath.Split(pattern)
@@ -63,7 +117,7 @@ func matchRecursive(root, pattern string) []string {
 	}
 	filepath.Walk(fullRoot, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			plog.Exc(err)
+			errs.Add(err)
 			return nil
 		}
 		// Ignore directories
@@ -73,8 +127,9 @@ func matchRecursive(root, pattern string) []string {
 		subpath := path[len(root)+1:]
 		matched, err := filepath.Match(pattern, filepath.Base(path))
 		if err != nil {
-			plog.Exc(err)
-			return nil
+			// The pattern is malformed, so nothing else will match either
+			errs.Add(err)
+			return err
 		}
 		if matched {
 			paths = append(paths, subpath)
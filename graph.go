@@ -1,6 +1,8 @@
 package pike
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -8,6 +10,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -21,73 +25,402 @@ type Graph struct {
 	nodes  []*Node
 	Source *Node
 	Sink   *Node
+	// true once the saved state has been loaded (see SetStateDir)
+	stateLoaded bool
 }
 
 // NewGraph is a simple constructor for Graph
 func NewGraph(name string) *Graph {
-	return &Graph{name, make([]*Node, 0, 10), nil, nil}
+	return &Graph{name, make([]*Node, 0, 10), nil, nil, false}
 }
 
-// Add will add any number of nodes to a Graph. The Graph will also
-// walk the nodes' ancestors and children and add those Nodes as well.
+// Add will add any number of nodes to a Graph. The Graph will also add
+// every Node that is connected to them, either directly or through other
+// Nodes. Each Node is only visited once, so it is safe to call this on
+// Graphs with diamonds or cycles.
 func (graph *Graph) Add(nodes ...*Node) {
-	allNodes := make(map[*Node]bool)
+	seen := make(map[*Node]bool)
 	for _, n := range graph.nodes {
-		allNodes[n] = true
+		seen[n] = true
 	}
+	queue := make([]*Node, 0, len(nodes))
 	for _, n := range nodes {
-		children := make(chan *Node)
-		go n.Walk(children)
-		for child := range children {
-			allNodes[child] = true
+		if !seen[n] {
+			seen[n] = true
+			queue = append(queue, n)
 		}
-		parents := make(chan *Node)
-		go n.WalkUp(parents)
-		for parent := range parents {
-			allNodes[parent] = true
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		graph.nodes = append(graph.nodes, n)
+		for _, neighbors := range [][]*Node{n.Inputs, n.Outputs} {
+			for _, next := range neighbors {
+				if next != nil && !seen[next] {
+					seen[next] = true
+					queue = append(queue, next)
+				}
+			}
 		}
 	}
+}
 
-	for n := range allNodes {
-		graph.nodes = append(graph.nodes, n)
+// edgeName describes the edge from the i'th output of one Node to another
+// (e.g. "coffee output 2 -> write" or "coffee output "map" -> write").
+func edgeName(from *Node, i int, to *Node) string {
+	if port := from.PortName(i); port != "" {
+		return fmt.Sprintf("%s output %q -> %s", from.Name, port, to.Name)
+	}
+	return fmt.Sprintf("%s output %d -> %s", from.Name, i, to.Name)
+}
+
+// findCycle returns the Nodes in the first cycle found in the Graph, with
+// the first Node repeated at the end. Returns nil if there are no cycles.
+func (graph *Graph) findCycle() []*Node {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*Node]int)
+	path := make([]*Node, 0, len(graph.nodes))
+	var visit func(n *Node) []*Node
+	visit = func(n *Node) []*Node {
+		state[n] = visiting
+		path = append(path, n)
+		for _, next := range n.Outputs {
+			if next == nil {
+				continue
+			}
+			switch state[next] {
+			case visiting:
+				start := nodeIndex(path, next)
+				cycle := append([]*Node{}, path[start:]...)
+				return append(cycle, next)
+			case unvisited:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[n] = visited
+		return nil
 	}
+	for _, n := range graph.nodes {
+		if state[n] == unvisited {
+			if cycle := visit(n); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
 }
 
-// Check the input and output edges for constraint violations.
+// Check the Graph for cycles and the input and output edges for
+// constraint violations. All problems are reported in a single error.
 func (graph *Graph) validate() error {
+	problems := make([]string, 0)
+	inGraph := make(map[*Node]bool, len(graph.nodes))
 	for _, n := range graph.nodes {
+		inGraph[n] = true
+	}
+	for _, n := range graph.nodes {
+		problems = append(problems, n.problems...)
+		for i, next := range n.Outputs {
+			if next == nil {
+				continue
+			}
+			edge := edgeName(n, i, next)
+			if !inGraph[next] {
+				problems = append(problems, fmt.Sprintf(
+					"%s: %s is not in the graph", edge, next.Name))
+			} else if nodeIndex(next.Inputs, n) == -1 {
+				problems = append(problems, fmt.Sprintf(
+					"%s: %s does not list %s as an input", edge, next.Name, n.Name))
+			}
+		}
+		for _, prev := range n.Inputs {
+			if !inGraph[prev] {
+				problems = append(problems, fmt.Sprintf(
+					"%s -> %s: %s is not in the graph", prev.Name, n.Name, prev.Name))
+			} else if nodeIndex(prev.Outputs, n) == -1 {
+				problems = append(problems, fmt.Sprintf(
+					"%s -> %s: %s does not list %s as an output", prev.Name,
+					n.Name, prev.Name, n.Name))
+			}
+		}
 		if n.MaxInputs >= 0 && len(n.Inputs) > n.MaxInputs {
-			return errors.New(fmt.Sprintf("%v has too many inputs", n))
+			prev := n.Inputs[n.MaxInputs]
+			problems = append(problems, fmt.Sprintf(
+				"%s: %s has too many inputs (max %d)",
+				edgeName(prev, nodeIndex(prev.Outputs, n), n), n.Name, n.MaxInputs))
 		}
 		if len(n.Inputs) < n.MinInputs && n != graph.Source {
-			return errors.New(fmt.Sprintf("%v has too few inputs", n))
+			problems = append(problems, fmt.Sprintf(
+				"%s has too few inputs (has %d, needs %d)", n.Name,
+				len(n.Inputs), n.MinInputs))
 		}
 		if n.MaxOutputs >= 0 && len(n.Outputs) > n.MaxOutputs {
-			return errors.New(fmt.Sprintf("%v has too many outputs", n))
+			problems = append(problems, fmt.Sprintf(
+				"%s: %s has too many outputs (max %d)",
+				edgeName(n, n.MaxOutputs, n.Outputs[n.MaxOutputs]), n.Name,
+				n.MaxOutputs))
 		}
 		if len(n.Outputs) != 0 && len(n.Outputs) < n.MinOutputs && n != graph.Sink {
-			return errors.New(fmt.Sprintf("%v has too few outputs", n))
+			problems = append(problems, fmt.Sprintf(
+				"%s has too few outputs (has %d, needs %d)", n.Name,
+				len(n.Outputs), n.MinOutputs))
 		}
 	}
+	if cycle := graph.findCycle(); cycle != nil {
+		problems = append(problems, fmt.Sprintf("cycle detected: %s",
+			describeCycle(cycle)))
+	}
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("invalid graph %q:\n  %s", graph.Name,
+			strings.Join(problems, "\n  ")))
+	}
 	return nil
 }
 
-// Run will start running a Graph. It will return a WaitGroup that can
-// be used to detect when the Graph has processed all files.
-func (graph *Graph) Run() (*sync.WaitGroup, error) {
+// Execution is a handle to a running Graph.
+type Execution struct {
+	graph     *Graph
+	ctx       context.Context
+	waitGroup *sync.WaitGroup
+	lock      sync.Mutex
+	errors    Errors
+	saveOnce  sync.Once
+	stats     *statsCollector
+	started   time.Time
+	// when the run ended, which is set before done is closed
+	finished time.Time
+	// closed when all of the Nodes are done (see finish)
+	done       chan struct{}
+	finishOnce sync.Once
+	// set if the watchdog cancelled the run (see SetHangTimeout)
+	hung error
+}
+
+// Wait blocks until the Graph has processed all files. If any Nodes
+// reported errors, they will be returned as an Errors list. If the Graph
+// was cancelled, the list will also contain the error from the context.
+func (self *Execution) Wait() error {
+	<-self.done
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	errs := self.errors
+	if self.hung != nil {
+		errs = append(errs, &NodeError{Graph: self.graph.Name, Err: self.hung})
+	} else if self.ctx != nil && self.ctx.Err() != nil {
+		errs = append(errs, &NodeError{Graph: self.graph.Name, Err: self.ctx.Err()})
+	}
+	// Only save the state of complete, successful runs. Otherwise the files
+	// that failed would be skipped the next time.
+	if self.ctx != nil && len(errs) == 0 {
+		self.saveOnce.Do(func() {
+			if err := self.graph.saveState(); err != nil {
+				plog.Error("Error saving state for %q", self.graph.Name)
+				plog.Exc(err)
+			}
+		})
+	}
+	return errs.Err()
+}
+
+// finish marks the Execution as done, and records the time so that the
+// wall time in the stats doesn't depend on when Wait is called.
+func (self *Execution) finish() {
+	self.finishOnce.Do(func() {
+		self.lock.Lock()
+		self.finished = time.Now()
+		self.lock.Unlock()
+		close(self.done)
+	})
+}
+
+// Stats returns the measurements for every Node in the run, including the
+// Nodes inside of subgraphs. It blocks until the Graph is done.
+func (self *Execution) Stats() *RunStats {
+	self.Wait()
+	return self.stats.runStats(self.finished.Sub(self.started))
+}
+
+// addError records an error from a Node. It returns the number of errors
+// that came from the Node itself, rather than from Nodes in a subgraph.
+func (self *Execution) addError(graph *Graph, node *Node, err error) int {
+	errs := make(Errors, 0, 1)
+	errs.Add(err)
+	count := 0
+	for _, e := range errs {
+		// Errors from subgraphs will already have been logged
+		if e.Node == "" {
+			e.Node = node.Name
+			e.Graph = graph.Name
+			plog.Exc(e)
+			count++
+		}
+	}
+	self.lock.Lock()
+	self.errors = append(self.errors, errs...)
+	self.lock.Unlock()
+	return count
+}
+
+// Run will start running a Graph. It will return an Execution that can be
+// used to wait for the Graph to process all files and collect any errors.
+func (graph *Graph) Run() (*Execution, error) {
+	return graph.RunContext(context.Background())
+}
+
+// RunContext is the same as Run, but the Graph will stop when the context
+// is cancelled. Sources will stop reading files, external processes will
+// be killed, and the remaining files will be discarded.
+func (graph *Graph) RunContext(ctx context.Context) (*Execution, error) {
 	if err := graph.validate(); err != nil {
 		return nil, err
 	}
+	if err := graph.Preflight(ctx); err != nil {
+		return nil, err
+	}
 	if graph.Source != nil {
 		return nil, errors.New("Cannot run a graph with a source!")
 	}
 	if graph.Sink != nil {
 		return nil, errors.New("Cannot run a graph with a sink!")
 	}
-	return graph.start(make([]chan File, 0), make([]chan File, 0))
+	graph.loadState()
+	ctx, collector := withStatsCollector(ctx)
+	runCtx, cancel := context.WithCancel(ctx)
+	execution, err := graph.start(runCtx, make([]chan File, 0), make([]chan File, 0))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Use the parent context to report errors, since the watchdog cancels
+	// runCtx once the run is over
+	execution.ctx = ctx
+	execution.stats = collector
+	go execution.watchForHang(hangConfig.Timeout, cancel)
+	return execution, nil
+}
+
+// The number of files that can be waiting on an edge between two Nodes
+const linkBuffer = 10
+
+// link forwards files from one channel to another until 'from' is closed,
+// holding up to linkBuffer files at a time. If the context is cancelled, it
+// will close 'to' early and discard the rest of the files so that neither
+// end of the edge blocks forever. Files passing through are counted for
+// the Nodes on either end, along with the time that the downstream Node is
+// waiting for files and the time that the upstream Node is waiting for room
+// on the edge. If 'abandon' is closed, the upstream Node has stopped
+// without closing 'from', so the files already on the edge are delivered
+// and then 'to' is closed, while anything sent on 'from' later is
+// discarded.
+func link(ctx context.Context, from, to chan File, edge *edgeStats, abandon chan struct{}) {
+	defer close(to)
+	defer edge.close()
+	up, down := edge.up, edge.down
+	queue := make([]File, 0, linkBuffer)
+	starved, full := true, false
+	defer func() {
+		if full {
+			up.setFull(false)
+		}
+		if !starved {
+			down.setStarved(true)
+		}
+		down.closeInput()
+	}()
+	for from != nil || len(queue) > 0 {
+		var recv, send chan File
+		var next File
+		if from != nil && len(queue) < linkBuffer {
+			recv = from
+		}
+		if len(queue) > 0 {
+			send = to
+			next = queue[0]
+		}
+		select {
+		case file, ok := <-recv:
+			if !ok {
+				from = nil
+				continue
+			}
+			up.sent(file)
+			queue = append(queue, file)
+		case send <- next:
+			down.received(next)
+			edge.delivered()
+			queue[0] = nil
+			queue = queue[1:]
+		case <-ctx.Done():
+			// Don't wait for the drain to finish. If the upstream Node
+			// never closes its output, it would block forever.
+			if from != nil {
+				go drain(from)
+			}
+			return
+		case <-abandon:
+			if from != nil {
+				go drain(from)
+				from = nil
+			}
+			abandon = nil
+			continue
+		}
+		edge.moved(len(queue))
+		if isStarved := len(queue) == 0; isStarved != starved {
+			starved = isStarved
+			down.setStarved(starved)
+		}
+		if isFull := len(queue) == linkBuffer; isFull != full {
+			full = isFull
+			up.setFull(full)
+		}
+	}
+}
+
+// drain discards all files from a channel until it is closed.
+func drain(c chan File) {
+	for _ = range c {
+	}
 }
 
-func (graph *Graph) start(in, out []chan File) (*sync.WaitGroup, error) {
+// runNode runs a Node's Runnable. If it panics, the panic is returned as a
+// PanicError, and the Node's channels are cleaned up so that the rest of
+// the Graph can finish: the inputs are drained, and 'abandon' is closed so
+// that the edges reading the outputs close their other ends. The outputs
+// themselves are never closed here, because a goroutine that the Node
+// started could still be sending on them. Anything it sends is discarded.
+func runNode(ctx context.Context, n *Node, in, out []chan File,
+	abandon chan struct{}) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		err = &PanicError{Value: r, Stack: debug.Stack()}
+		close(abandon)
+		// Drain the inputs at the same time, since one input could be
+		// waiting on another
+		var wg sync.WaitGroup
+		for _, c := range in {
+			wg.Add(1)
+			go func(c chan File) {
+				drain(c)
+				wg.Done()
+			}(c)
+		}
+		wg.Wait()
+	}()
+	return n.Runner.Run(ctx, in, out)
+}
+
+func (graph *Graph) start(ctx context.Context, in, out []chan File) (*Execution, error) {
 	if err := graph.validate(); err != nil {
 		return nil, err
 	}
@@ -95,8 +428,47 @@ func (graph *Graph) start(in, out []chan File) (*sync.WaitGroup, error) {
 	outMap := make(map[*Node][]chan File)
 
 	waitGroup := &sync.WaitGroup{}
+	collector := statsCollectorFrom(ctx)
+	tracer := tracerFrom(ctx)
+	execution := &Execution{graph: graph, waitGroup: waitGroup,
+		stats: collector, started: time.Now(), done: make(chan struct{})}
+	statsMap := make(map[*Node]*nodeStats)
+	// Closed if a Node panics, to tell the readers of its outputs
+	abandoned := make(map[*Node]chan struct{})
+	connect := func(name string, from, to chan File, fromNode *Node, port int,
+		up, down *nodeStats, abandon chan struct{}) {
+		down.addInput()
+		edge := collector.trackEdge(name, fromNode, port, up, down)
+		waitGroup.Add(1)
+		go func() {
+			link(ctx, from, to, edge, abandon)
+			waitGroup.Done()
+		}()
+	}
+	discard := func(up *nodeStats, abandon chan struct{}) chan File {
+		c := make(chan File, linkBuffer)
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for {
+				select {
+				case file, ok := <-c:
+					if !ok {
+						return
+					}
+					up.sent(file)
+				case <-abandon:
+					go drain(c)
+					return
+				}
+			}
+		}()
+		return c
+	}
 	// First pass creates the channel slices
 	for _, n := range graph.nodes {
+		statsMap[n] = collector.track(graph, n, tracer)
+		abandoned[n] = make(chan struct{})
 		inMap[n] = make([]chan File, len(n.Inputs))
 		// If node has dangling outputs, redirect them to channels that
 		// consume and discard
@@ -107,37 +479,58 @@ func (graph *Graph) start(in, out []chan File) (*sync.WaitGroup, error) {
 			}
 			outMap[n] = make([]chan File, numOutputs)
 			for i := 0; i < numOutputs; i++ {
-				c := make(chan File, 10)
-				outMap[n][i] = c
-				waitGroup.Add(1)
-				go func() {
-					for _ = range c {
-					}
-					waitGroup.Done()
-				}()
+				outMap[n][i] = discard(statsMap[n], abandoned[n])
 			}
 		} else {
 			outMap[n] = make([]chan File, len(n.Outputs))
+			// Ports that were skipped over are discarded as well
+			for i, next := range n.Outputs {
+				if next == nil {
+					outMap[n][i] = discard(statsMap[n], abandoned[n])
+				}
+			}
 		}
 	}
 
-	// Second pass creates the channels
+	// Second pass creates the channels and links them together
 	for _, n := range graph.nodes {
+		// Pair each input with the output of the same number, in case
+		// there is more than one edge from 'input'
+		seen := make(map[*Node]int)
 		for i, input := range n.Inputs {
-			j := nodeIndex(input.Outputs, n)
-			c := make(chan File, 10)
-			outMap[input][j] = c
-			inMap[n][i] = c
+			j := nthIndex(input.Outputs, n, seen[input])
+			seen[input]++
+			from := make(chan File)
+			to := make(chan File)
+			outMap[input][j] = from
+			inMap[n][i] = to
+			connect(edgeName(input, j, n), from, to, input, j, statsMap[input],
+				statsMap[n], abandoned[input])
 		}
 	}
 
-	// Set the input channels for the source
+	// Link the input channels to the source, and the sink to the output
+	// channels, so that their files are counted as well
 	if graph.Source != nil {
-		inMap[graph.Source] = in
+		inMap[graph.Source] = make([]chan File, len(in))
+		for i, from := range in {
+			to := make(chan File)
+			inMap[graph.Source][i] = to
+			name := fmt.Sprintf("graph(%q) input %d -> %s", graph.Name, i,
+				graph.Source.Name)
+			connect(name, from, to, nil, 0, nil, statsMap[graph.Source], nil)
+		}
 	}
-	// Set the output channels for the sink
 	if graph.Sink != nil {
-		outMap[graph.Sink] = out
+		outMap[graph.Sink] = make([]chan File, len(out))
+		for i, to := range out {
+			from := make(chan File)
+			outMap[graph.Sink][i] = from
+			name := fmt.Sprintf("%s -> graph(%q) output %d", graph.Sink.Name,
+				graph.Name, i)
+			connect(name, from, to, nil, 0, statsMap[graph.Sink], nil,
+				abandoned[graph.Sink])
+		}
 	}
 
 	// Start a goroutine for each Node
@@ -145,32 +538,55 @@ func (graph *Graph) start(in, out []chan File) (*sync.WaitGroup, error) {
 		n := n
 		waitGroup.Add(1)
 		go func() {
-			n.Runner.Run(inMap[n], outMap[n])
+			stats := statsMap[n]
+			stats.begin()
+			err := runNode(withNodeTrace(ctx, stats), n, inMap[n], outMap[n],
+				abandoned[n])
+			stats.finish()
+			collector.touch()
+			// Nodes that stopped because of a cancellation don't need to
+			// report it. The Execution will.
+			if err != nil && err != ctx.Err() {
+				stats.failed(execution.addError(graph, n, err))
+			}
 			waitGroup.Done()
 		}()
 	}
-	return waitGroup, nil
+	go func() {
+		waitGroup.Wait()
+		execution.finish()
+	}()
+	return execution, nil
 }
 
-// Watch will run the Graph continuously, sleeping for 'poll' between
-// runs. If you use this method, your Graph should contain some nodes
-// that watch for file changes (i.e. ChangeFilter), otherwise it
-// will just continually process all your files.
+// Watch will run the Graph, and then run it again every time the files
+// under its Glob roots change. 'poll' is how long to wait for a burst of
+// changes to settle before running. If the files can't be watched (for
+// example, on systems without inotify), it will instead run the Graph
+// continuously, sleeping for 'poll' between runs. Your Graph should contain
+// some nodes that watch for file changes (i.e. ChangeFilter), otherwise it
+// will process all of your files every time. Errors reported by the Nodes
+// are logged, and do not stop the Graph from running again. As with RunAll,
+// Ctrl-C only reaches external tools if SetToolProcessGroups is disabled.
 func (graph *Graph) Watch(poll time.Duration, quit chan int) error {
-	for {
-		waitGroup, err := graph.Run()
-		if err != nil {
-			return err
+	execution, err := graph.Run()
+	if err != nil {
+		return err
+	}
+	execution.Wait()
+	notifyDevServers()
+	watchLoop([]*Graph{graph}, poll, quit, func(changed []string) {
+		if len(affectedGraphs([]*Graph{graph}, changed)) == 0 {
+			return
 		}
-		waitGroup.Wait()
-
-		select {
-		case <-quit:
-			return nil
-		default:
-			time.Sleep(poll)
+		if execution, err := graph.Run(); err != nil {
+			plog.Exc(err)
+		} else {
+			execution.Wait()
+			notifyDevServers()
 		}
-	}
+	})
+	return nil
 }
 
 // Copy creates a deep copy of the Graph.
@@ -185,7 +601,8 @@ func (self *Graph) Copy() *Graph {
 		newNodes[i] = newNode
 		if node == self.Source {
 			source = newNode
-		} else if node == self.Sink {
+		}
+		if node == self.Sink {
 			sink = newNode
 		}
 	}
@@ -199,33 +616,52 @@ func (self *Graph) Copy() *Graph {
 		}
 	}
 
-	return &Graph{self.Name, newNodes, source, sink}
+	return &Graph{self.Name, newNodes, source, sink, false}
 }
 
 // GraphRunnable is a Runnable that delegates to a Graph.
 type GraphRunnable struct {
-	Fxn   func(in, out []chan File, graph *Graph)
+	Fxn   func(ctx context.Context, in, out []chan File, graph *Graph) error
 	Graph *Graph
 }
 
-func (self *GraphRunnable) Run(in, out []chan File) {
-	self.Fxn(in, out, self.Graph)
+func (self *GraphRunnable) Run(ctx context.Context, in, out []chan File) error {
+	return self.Fxn(ctx, in, out, self.Graph)
 }
 
 func (self *GraphRunnable) Copy() Runnable {
 	return &GraphRunnable{self.Fxn, self.Graph.Copy()}
 }
 
+func (self *GraphRunnable) MarshalState() ([]byte, error) {
+	state, err := self.Graph.marshalState()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(state)
+}
+
+func (self *GraphRunnable) UnmarshalState(data []byte) error {
+	if data == nil {
+		self.Graph.resetState()
+		return nil
+	}
+	state := &graphState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return err
+	}
+	return self.Graph.unmarshalState(state)
+}
+
 // Creates a Node that wraps the Graph. This allows you to use Graphs
 // as a single Node inside other Graphs.
 func (self *Graph) Node() *Node {
-	f := func(in, out []chan File, graph *Graph) {
-		waitGroup, err := graph.start(in, out)
+	f := func(ctx context.Context, in, out []chan File, graph *Graph) error {
+		execution, err := graph.start(ctx, in, out)
 		if err != nil {
-			plog.Exc(err)
-			return
+			return err
 		}
-		waitGroup.Wait()
+		return execution.Wait()
 	}
 	minIn, maxIn, minOut, maxOut := 0, 0, 0, 0
 	if self.Source != nil {
@@ -237,39 +673,159 @@ func (self *Graph) Node() *Node {
 		maxOut = self.Sink.MaxOutputs
 	}
 	runner := &GraphRunnable{f, self.Copy()}
-	return NewNode(fmt.Sprintf("graph(%q)", self.Name), minIn, maxIn,
+	node := NewNode(fmt.Sprintf("graph(%q)", self.Name), minIn, maxIn,
 		minOut, maxOut, runner)
+	if self.Sink != nil {
+		node.OutputPorts = self.Sink.OutputPorts
+	}
+	return node
 }
 
 // Dot returns the dot representation of the Graph. If indent is not
-// "", it will render this graph in the format of a subgraph.
+// "", it will render this graph in the format of a subgraph. Graphs that
+// are used as Nodes are rendered as subgraphs with all of their own Nodes,
+// and the edges to them go to their Source and come from their Sink. The
+// Node IDs are based on the order of the Nodes in the Graph, so the output
+// is the same every time.
 func (self *Graph) Dot(indent string) string {
+	return self.dot(indent, defaultStyle)
+}
+
+func (self *Graph) dot(indent string, style *graphStyle) string {
 	re := regexp.MustCompile("[^A-Za-z0-9_\\-]")
-	name := re.ReplaceAll([]byte(self.Name), []byte("_"))
+	name := re.ReplaceAllString(self.Name, "_")
 
 	lines := make([]string, 0, 20)
-
+	prefix := "n"
 	if len(indent) > 0 {
+		// Keep the IDs unique if several Graphs are put in one file
+		prefix = name + "_n"
 		lines = append(lines, fmt.Sprintf("%ssubgraph cluster_%s {",
 			indent, name))
-		lines = append(lines, fmt.Sprintf("%s  label = \"%s\";",
+		lines = append(lines, fmt.Sprintf("%s  label = %q;",
 			indent, self.Name))
 	} else {
-		lines = append(lines, fmt.Sprintf("%sdigraph %s {",
-			indent, name))
+		lines = append(lines, fmt.Sprintf("digraph %s {", name))
 	}
-	for _, node := range self.nodes {
-		lines = append(lines, node.Dot(indent+"  "))
+	ids := make(map[*Node]string)
+	self.nodeIds(prefix, ids)
+	lines = self.dotLines(indent+"  ", ids, style, lines)
+	lines = append(lines, indent+"}")
+	return strings.Join(lines, "\n")
+}
+
+// nodeIds assigns an ID to every Node in the Graph and its subgraphs, based
+// on the position of the Node in the Graph (e.g. the third Node inside of
+// the second Node is "n1_2").
+func (self *Graph) nodeIds(prefix string, ids map[*Node]string) {
+	for i, n := range self.nodes {
+		id := prefix + strconv.Itoa(i)
+		ids[n] = id
+		if runner, ok := n.Runner.(*GraphRunnable); ok {
+			runner.Graph.nodeIds(id+"_", ids)
+		}
+	}
+}
+
+// exitNode returns the Node that files leaving 'n' actually come from. For
+// a Node that wraps a Graph, that's the Graph's Sink.
+func exitNode(n *Node) *Node {
+	for {
+		runner, ok := n.Runner.(*GraphRunnable)
+		if !ok || runner.Graph.Sink == nil {
+			return n
+		}
+		n = runner.Graph.Sink
+	}
+}
+
+// entryNode returns the Node that files sent to 'n' actually go to. For a
+// Node that wraps a Graph, that's the Graph's Source.
+func entryNode(n *Node) *Node {
+	for {
+		runner, ok := n.Runner.(*GraphRunnable)
+		if !ok || runner.Graph.Source == nil {
+			return n
+		}
+		n = runner.Graph.Source
+	}
+}
+
+func (self *Graph) dotLines(indent string, ids map[*Node]string, style *graphStyle,
+	lines []string) []string {
+	for _, n := range self.nodes {
+		attrs := ""
+		if style.failed(n) {
+			attrs = ` color="#cc0000" fontcolor="#cc0000"`
+		}
+		if runner, ok := n.Runner.(*GraphRunnable); ok {
+			lines = append(lines, fmt.Sprintf("%ssubgraph cluster_%s {", indent, ids[n]))
+			lines = append(lines, fmt.Sprintf("%s  label = %q;", indent,
+				strings.Join(style.label(n), "\n")))
+			if attrs != "" {
+				lines = append(lines, fmt.Sprintf("%s  color = \"#cc0000\";", indent))
+			}
+			lines = runner.Graph.dotLines(indent+"  ", ids, style, lines)
+			lines = append(lines, indent+"}")
+		} else {
+			if attrs != "" {
+				attrs += ` style=filled fillcolor="#ffdddd"`
+			}
+			lines = append(lines, fmt.Sprintf("%s%s [label=%q%s];", indent, ids[n],
+				strings.Join(style.label(n), "\n"), attrs))
+		}
+	}
+	for _, n := range self.nodes {
+		for i, next := range n.Outputs {
+			// Ports that were skipped over have no edge
+			if next == nil {
+				continue
+			}
+			from, okFrom := ids[exitNode(n)]
+			to, okTo := ids[entryNode(next)]
+			if !okFrom || !okTo {
+				continue
+			}
+			attrs := make([]string, 0, 2)
+			if label := style.edgeLabel(n, i); label != "" {
+				attrs = append(attrs, fmt.Sprintf("label=%q", label))
+			}
+			if weight := style.edgeWeight(n, i); weight > 1 {
+				attrs = append(attrs, fmt.Sprintf("penwidth=%d", weight))
+			}
+			dotEdge := fmt.Sprintf("%s%s -> %s", indent, from, to)
+			if len(attrs) > 0 {
+				dotEdge += " [" + strings.Join(attrs, " ") + "]"
+			}
+			lines = append(lines, dotEdge+";")
+		}
 	}
-	lines = append(lines, "}")
-	return strings.Join(lines, fmt.Sprintf("\n%s", indent))
+	return lines
 }
 
-// Render will use the dot format to render an image for the Graph. The
-// file type is determined by the extension on 'outfile'. Requires
+// Render will draw the Graph to a file. The file type is determined by the
+// extension on 'outfile'. ".svg" and ".txt" files are drawn by pike (see
+// Svg and Ascii), and ".dot" and ".mmd" files get the output of Dot and
+// Mermaid. Any other type is rendered from the dot format, which requires
 // graphviz.
 func (self *Graph) Render(outfile string) error {
+	return self.render(outfile, defaultStyle)
+}
+
+func (self *Graph) render(outfile string, style *graphStyle) error {
 	ext := filepath.Ext(outfile)
+	switch ext {
+	case ".svg":
+		return ioutil.WriteFile(outfile, []byte(self.svg(style)), 0644)
+	case ".txt":
+		return ioutil.WriteFile(outfile, []byte(self.ascii(style)), 0644)
+	case ".dot":
+		return ioutil.WriteFile(outfile, []byte(self.dot("", style)+"\n"), 0644)
+	case ".mmd":
+		return ioutil.WriteFile(outfile, []byte(self.Mermaid()), 0644)
+	case "":
+		return errors.New(fmt.Sprintf("Cannot render %q without a file extension", outfile))
+	}
 	imageFormat := ext[1:]
 
 	dotFile, err := ioutil.TempFile("", "graph")
@@ -278,7 +834,7 @@ func (self *Graph) Render(outfile string) error {
 	}
 	defer dotFile.Close()
 	defer os.Remove(dotFile.Name())
-	_, err = dotFile.Write([]byte(self.Dot("")))
+	_, err = dotFile.Write([]byte(self.dot("", style)))
 	if err != nil {
 		return err
 	}
@@ -294,27 +850,74 @@ func (self *Graph) Render(outfile string) error {
 	return nil
 }
 
-// RunAll runs a slice of Graphs and blocks until they all complete.
-func RunAll(graphs []*Graph) {
-	groups := make([]*sync.WaitGroup, 0, 10)
+// RunAll runs a slice of Graphs and blocks until they all complete. It
+// returns an Errors list with every error reported by the Graphs. Unless
+// SetToolProcessGroups is enabled, external tools share the terminal's
+// process group, so Ctrl-C stops them along with the program.
+func RunAll(graphs []*Graph) error {
+	return RunAllContext(context.Background(), graphs)
+}
+
+// RunAllContext is the same as RunAll, but all of the Graphs will stop
+// when the context is cancelled.
+func RunAllContext(ctx context.Context, graphs []*Graph) error {
+	_, err := RunAllStats(ctx, graphs)
+	return err
+}
+
+// RunAllStats is the same as RunAllContext, but it also returns the
+// measurements for every Node in the Graphs.
+func RunAllStats(ctx context.Context, graphs []*Graph) (*RunStats, error) {
+	var errs Errors
+	started := time.Now()
+	executions := make([]*Execution, 0, 10)
 	for _, g := range graphs {
-		waitGroup, err := g.Run()
+		execution, err := g.RunContext(ctx)
 		if err != nil {
 			plog.Exc(err)
+			errs.Add(&NodeError{Graph: g.Name, Err: err})
 		} else {
-			groups = append(groups, waitGroup)
+			executions = append(executions, execution)
 		}
 	}
-	for _, wg := range groups {
-		wg.Wait()
+	runs := make([]*RunStats, 0, len(executions))
+	for _, execution := range executions {
+		errs.Add(execution.Wait())
+		runs = append(runs, execution.Stats())
 	}
+	return mergeStats(time.Since(started), runs...), errs.Err()
 }
 
-// WatchAll will run a slice of Graphs continuously until the program
-// quits.
+// WatchAll will run a slice of Graphs, and then run them again every time
+// their files change until the program quits. Only the Graphs whose Globs
+// match the changed files will be run again. After each run, any DevServers
+// are notified of the files that were written. See Graph.Watch.
 func WatchAll(graphs []*Graph, poll time.Duration) {
-	for {
-		RunAll(graphs)
-		time.Sleep(poll)
+	watchAll(context.Background(), graphs, poll, nil)
+}
+
+// watchAll is WatchAll, but it runs the Graphs with 'ctx' and passes the
+// stats from every run to 'report' if it is not nil. It returns once 'ctx'
+// is cancelled and the current run has stopped.
+func watchAll(ctx context.Context, graphs []*Graph, poll time.Duration, report func(*RunStats)) {
+	run := func(graphs []*Graph) {
+		stats, _ := RunAllStats(ctx, graphs)
+		notifyDevServers()
+		if report != nil {
+			report(stats)
+		}
 	}
+	quit := make(chan int)
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			close(quit)
+		}()
+	}
+	run(graphs)
+	watchLoop(graphs, poll, quit, func(changed []string) {
+		if affected := affectedGraphs(graphs, changed); len(affected) > 0 {
+			run(affected)
+		}
+	})
 }
@@ -0,0 +1,71 @@
+package pike
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// source creates a Node with no inputs that sends one File to each of its
+// named outputs.
+func source(ports ...string) *Node {
+	f := func(ctx context.Context, in, out []chan File) error {
+		for i, c := range out {
+			c <- NewFile("src", ports[i], nil)
+			close(c)
+		}
+		return nil
+	}
+	return NewPortNode("source", 0, 0, 1, ports, FxnRunnable(f))
+}
+
+// collect creates a Node that records the names of the Files it receives.
+func collect(names *[]string) *Node {
+	return NewFuncNode("collect", func(ctx context.Context, in, out chan File) error {
+		for file := range in {
+			*names = append(*names, file.Name())
+		}
+		return nil
+	})
+}
+
+func TestTwoPortsIntoMerge(t *testing.T) {
+	src := source("js", "map")
+	merge := Merge()
+	src.Port("js").Pipe(merge)
+	src.Port("map").Pipe(merge)
+	var names []string
+	merge.Pipe(collect(&names))
+	graph := NewGraph("ports")
+	graph.Add(src)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	execution, err := graph.RunContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := execution.Wait(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(names) != 2 || names[0] != "js" || names[1] != "map" {
+		t.Errorf("Expected [js map], got %v", names)
+	}
+}
+
+func TestWallTimeIgnoresLateWait(t *testing.T) {
+	var names []string
+	src := source("js")
+	src.Pipe(collect(&names))
+	graph := NewGraph("late")
+	graph.Add(src)
+
+	execution, err := graph.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if wall := execution.Stats().Wall; wall >= 200*time.Millisecond {
+		t.Errorf("Expected the wall time to stop when the run ended, got %s", wall)
+	}
+}
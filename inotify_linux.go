@@ -0,0 +1,130 @@
+//go:build linux
+// +build linux
+
+package pike
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/stevearc/pike/plog"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_ATTRIB
+
+// inotifyWatcher uses the Linux inotify API to watch directory trees.
+type inotifyWatcher struct {
+	fd      int
+	file    *os.File
+	lock    sync.Mutex
+	watches map[int]string
+	events  chan string
+}
+
+func newFileWatcher(roots []string) (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// The fd is non-blocking, so the os.File will use the runtime poller and
+	// Close will interrupt a pending Read.
+	watcher := &inotifyWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int]string),
+		events:  make(chan string, 100),
+	}
+	for _, root := range roots {
+		if err = watcher.addRecursive(root, false); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	go watcher.readEvents()
+	return watcher, nil
+}
+
+// addRecursive adds a watch for a directory and all of its subdirectories.
+// If 'created' is true, the directory is new and any files already in it
+// are reported as changed.
+func (self *inotifyWatcher) addRecursive(root string, created bool) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Directories may vanish while we walk them
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			if created {
+				self.events <- path
+			}
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(self.fd, path, inotifyMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		self.lock.Lock()
+		self.watches[wd] = path
+		self.lock.Unlock()
+		return nil
+	})
+}
+
+func (self *inotifyWatcher) readEvents() {
+	defer close(self.events)
+	var buf [syscall.SizeofInotifyEvent * 4096]byte
+	for {
+		n, err := self.file.Read(buf[:])
+		if err != nil {
+			return
+		}
+		offset := 0
+		for offset+syscall.SizeofInotifyEvent <= n {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := string(buf[nameStart : nameStart+int(event.Len)])
+			name = strings.TrimRight(name, "\x00")
+			offset = nameStart + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// Events were dropped, so we don't know what changed
+				self.events <- ""
+				continue
+			}
+			self.lock.Lock()
+			dir, ok := self.watches[int(event.Wd)]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(self.watches, int(event.Wd))
+			}
+			self.lock.Unlock()
+			if !ok || name == "" {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			isDir := event.Mask&syscall.IN_ISDIR != 0
+			if isDir && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if err := self.addRecursive(path, true); err != nil {
+					plog.Warn("Could not watch %q: %v", path, err)
+				}
+			}
+			self.events <- path
+		}
+	}
+}
+
+func (self *inotifyWatcher) Events() <-chan string {
+	return self.events
+}
+
+func (self *inotifyWatcher) Close() error {
+	return self.file.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package pike
+
+import "errors"
+
+func newFileWatcher(roots []string) (fileWatcher, error) {
+	return nil, errors.New("file watching is only supported on Linux")
+}
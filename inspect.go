@@ -0,0 +1,155 @@
+package pike
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Edge is a connection from one output of a Node to one input of another.
+type Edge struct {
+	From *Node
+	// The index of the output on From
+	Port int
+	To   *Node
+	// The index of the input on To
+	Input int
+}
+
+// PortName returns the name of the output on From, or "" if it has none.
+func (self Edge) PortName() string {
+	return self.From.PortName(self.Port)
+}
+
+func (self Edge) String() string {
+	return edgeName(self.From, self.Port, self.To)
+}
+
+// Nodes returns all of the Nodes in the Graph, in the order they were
+// added. Graphs that are used as Nodes are not expanded; their Nodes can be
+// found through the Node's Runner, which is a *GraphRunnable.
+func (graph *Graph) Nodes() []*Node {
+	return append([]*Node{}, graph.nodes...)
+}
+
+// Sources returns the Nodes that have no inputs, in the order they were
+// added. This includes the Graph's Source, if it has one.
+func (graph *Graph) Sources() []*Node {
+	sources := make([]*Node, 0, 4)
+	for _, n := range graph.nodes {
+		if len(n.Inputs) == 0 {
+			sources = append(sources, n)
+		}
+	}
+	return sources
+}
+
+// Terminals returns the Nodes that have no outputs connected, in the order
+// they were added. This includes the Graph's Sink, if it has one.
+func (graph *Graph) Terminals() []*Node {
+	terminals := make([]*Node, 0, 4)
+	for _, n := range graph.nodes {
+		connected := false
+		for _, next := range n.Outputs {
+			if next != nil {
+				connected = true
+				break
+			}
+		}
+		if !connected {
+			terminals = append(terminals, n)
+		}
+	}
+	return terminals
+}
+
+// Edges returns all of the edges between Nodes in the Graph. They are in
+// the order of the Node they come from, and then the output port. If two
+// Nodes are connected more than once, the outputs and inputs are paired in
+// order.
+func (graph *Graph) Edges() []Edge {
+	edges := make([]Edge, 0, len(graph.nodes))
+	for _, n := range graph.nodes {
+		for i, next := range n.Outputs {
+			if next == nil {
+				continue
+			}
+			edges = append(edges, Edge{From: n, Port: i, To: next,
+				Input: inputIndex(n, i)})
+		}
+	}
+	return edges
+}
+
+// Find returns the first Node in the Graph with a name, or nil if there is
+// none. Node names don't have to be unique, so use Nodes to find all of
+// them.
+func (graph *Graph) Find(name string) *Node {
+	for _, n := range graph.nodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// Topological returns the Nodes of the Graph ordered so that every Node
+// comes after all of its inputs. When there is a choice, Nodes are kept in
+// the order they were added, so the result is the same every time. It
+// returns an error if the Graph has a cycle.
+func (graph *Graph) Topological() ([]*Node, error) {
+	if cycle := graph.findCycle(); cycle != nil {
+		return nil, errors.New(fmt.Sprintf("Graph %q has a cycle: %s",
+			graph.Name, describeCycle(cycle)))
+	}
+	index := make(map[*Node]int, len(graph.nodes))
+	for i, n := range graph.nodes {
+		index[n] = i
+	}
+	waiting := make(map[*Node]int, len(graph.nodes))
+	for _, edge := range graph.Edges() {
+		if _, ok := index[edge.To]; ok {
+			waiting[edge.To]++
+		}
+	}
+	ready := make([]*Node, 0, len(graph.nodes))
+	push := func(n *Node) {
+		i := sort.Search(len(ready), func(i int) bool {
+			return index[ready[i]] > index[n]
+		})
+		ready = append(ready, nil)
+		copy(ready[i+1:], ready[i:])
+		ready[i] = n
+	}
+	for _, n := range graph.nodes {
+		if waiting[n] == 0 {
+			push(n)
+		}
+	}
+	order := make([]*Node, 0, len(graph.nodes))
+	for len(ready) > 0 {
+		n := ready[0]
+		ready = ready[1:]
+		order = append(order, n)
+		for _, next := range n.Outputs {
+			if _, ok := index[next]; !ok {
+				continue
+			}
+			waiting[next]--
+			if waiting[next] == 0 {
+				push(next)
+			}
+		}
+	}
+	return order, nil
+}
+
+// describeCycle formats a cycle from findCycle (e.g. "a -> b -> a").
+func describeCycle(cycle []*Node) string {
+	names := make([]string, len(cycle))
+	for i, n := range cycle {
+		names[i] = n.Name
+	}
+	return strings.Join(names, " -> ")
+}
@@ -0,0 +1,47 @@
+package pike
+
+import "testing"
+
+func TestEdgesWithDuplicatePair(t *testing.T) {
+	src := source("js", "map", "source")
+	merge := Merge()
+	src.Port("map").Pipe(merge)
+	src.Port("js").Pipe(merge)
+	graph := NewGraph("ports")
+	graph.Add(src)
+
+	edges := graph.Edges()
+	if len(edges) != 2 {
+		t.Fatalf("Expected 2 edges, got %v", edges)
+	}
+	// Repeated edges between two Nodes pair up in port order, which is how
+	// the Graph is wired when it runs
+	want := []struct{ port, input int }{{0, 0}, {1, 1}}
+	for i, edge := range edges {
+		if edge.From != src || edge.To != merge || edge.Port != want[i].port ||
+			edge.Input != want[i].input {
+			t.Errorf("Edge %d: expected port %d -> input %d, got %s (port %d -> input %d)",
+				i, want[i].port, want[i].input, edge, edge.Port, edge.Input)
+		}
+	}
+}
+
+func TestJsonWithDuplicatePair(t *testing.T) {
+	src := source("js", "map")
+	merge := Merge()
+	src.Port("js").Pipe(merge)
+	src.Port("map").Pipe(merge)
+	graph := NewGraph("ports")
+	graph.Add(src)
+
+	ids := make(map[*Node]string)
+	graph.nodeIds("n", ids)
+	edges := graph.toJson(ids).Edges
+	if len(edges) != 2 {
+		t.Fatalf("Expected 2 edges, got %d", len(edges))
+	}
+	if edges[0].Input != 0 || edges[1].Input != 1 {
+		t.Errorf("Expected the edges to go to inputs 0 and 1, got %+v and %+v",
+			edges[0], edges[1])
+	}
+}
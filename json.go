@@ -1,7 +1,10 @@
 package pike
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -40,41 +43,36 @@ func SetJsonPretty(indent bool) {
 	config.Pretty = indent
 }
 
-func writeJsonFile() {
+func writeJsonFile() error {
 	config.Lock.Lock()
+	defer config.Lock.Unlock()
 	if config.Destination == "" {
-		plog.Error("Json file not set. Use node.SetJsonFile()")
-	} else {
-		// Make sure the directory exists
-		parent := filepath.Dir(config.Destination)
-		os.MkdirAll(parent, os.ModeDir|0755)
+		return errors.New("Json file not set. Use node.SetJsonFile()")
+	}
+	// Make sure the directory exists
+	parent := filepath.Dir(config.Destination)
+	os.MkdirAll(parent, os.ModeDir|0755)
 
-		// Write the file
-		plog.Info("Dumping json %q", config.Destination)
-		var err error
-		var jsonData []byte
-		if config.Pretty {
-			jsonData, err = json.MarshalIndent(cache.Output, "", "  ")
-		} else {
-			jsonData, err = json.Marshal(cache.Output)
-		}
-		if err != nil {
-			plog.Exc(err)
-		}
-		err = ioutil.WriteFile(config.Destination, jsonData, 0644)
-		if err != nil {
-			plog.Error("Error writing file %q", config.Destination)
-			plog.Exc(err)
-		}
+	// Write the file
+	plog.Info("Dumping json %q", config.Destination)
+	var err error
+	var jsonData []byte
+	if config.Pretty {
+		jsonData, err = json.MarshalIndent(cache.Output, "", "  ")
+	} else {
+		jsonData, err = json.Marshal(cache.Output)
 	}
-	config.Lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(config.Destination, jsonData, 0644)
 }
 
 // Json creates a Node that dumps the paths of all files into a json file.
 // The Json file is global (it's the same for ALL graphs in a process) and must
 // be set with SetJsonFile.
 func Json(key string) *Node {
-	f := func(in, out chan File) {
+	f := func(ctx context.Context, in, out chan File) error {
 		newFiles := false
 		for file := range in {
 			newFiles = true
@@ -92,9 +90,15 @@ func Json(key string) *Node {
 			}
 			out <- file
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if newFiles {
-			writeJsonFile()
+			return writeJsonFile()
 		}
+		return nil
 	}
-	return NewFuncNode("json", f)
+	node := NewFuncNode("json", f)
+	node.Options = fmt.Sprintf("%q", key)
+	return node
 }
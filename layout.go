@@ -0,0 +1,450 @@
+package pike
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// layoutMetrics are the sizes used to lay out a Graph. The text renderer
+// measures in character cells, and the SVG renderer measures in pixels.
+type layoutMetrics struct {
+	// The size of a character in a label
+	charWidth  int
+	lineHeight int
+	// The space between the edge of a Node's box and its label
+	nodePadX int
+	nodePadY int
+	// The space between Nodes in the same layer, and between layers
+	hGap int
+	vGap int
+	// The space between the edge of a subgraph's box and its Nodes. The top
+	// is larger to leave room for the label.
+	clusterPad int
+	clusterTop int
+	// The width of the placeholders for edges that skip over layers
+	dummyWidth int
+	// 1 if a box includes its last row and column (true for cells)
+	inset int
+}
+
+// layoutItem is a box in a layout. It is a Node, a subgraph, or a
+// placeholder for an edge that passes through a layer.
+type layoutItem struct {
+	node  *Node
+	lines []string
+	// The layout of the subgraph, if the Node wraps a Graph
+	sub   *layoutBox
+	dummy bool
+	layer int
+	// The position used while ordering the layers
+	pos float64
+	// x and y are relative to the parent box until the layout is placed
+	x, y, w, h int
+}
+
+func (self *layoutItem) centerX() int {
+	return self.x + self.w/2
+}
+
+// layoutEdge is an edge between two items in the same box.
+type layoutEdge struct {
+	from *layoutItem
+	to   *layoutItem
+	// The output index on from.node
+	port int
+	// The placeholders for the layers that the edge passes through
+	via []*layoutItem
+}
+
+// layoutBox is the layout of one Graph.
+type layoutBox struct {
+	graph  *Graph
+	items  []*layoutItem
+	byNode map[*Node]*layoutItem
+	edges  []*layoutEdge
+	layers [][]*layoutItem
+	// The neighbors of each item in the layer above
+	above map[*layoutItem][]*layoutItem
+	w, h  int
+}
+
+func textWidth(lines []string) int {
+	width := 0
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > width {
+			width = n
+		}
+	}
+	return width
+}
+
+// layoutGraph arranges the Nodes of a Graph in layers from top to bottom,
+// so that edges point down. Subgraphs are laid out recursively and placed
+// in the layers as a single large box. 'label' returns the lines of text
+// for a Node.
+func layoutGraph(graph *Graph, m layoutMetrics, label func(n *Node) []string) *layoutBox {
+	box := &layoutBox{graph: graph, byNode: make(map[*Node]*layoutItem)}
+	for _, n := range graph.nodes {
+		item := &layoutItem{node: n, lines: label(n)}
+		if runner, ok := n.Runner.(*GraphRunnable); ok {
+			item.sub = layoutGraph(runner.Graph, m, label)
+			// Clusters only have room for one line, in the top border
+			if len(item.lines) > 1 {
+				item.lines = item.lines[:1]
+			}
+			item.w = item.sub.w + 2*m.clusterPad
+			// Keep the label in the left half, out of the way of the edges
+			// that go to the Source in the middle
+			labelWidth := textWidth(item.lines)*m.charWidth + m.nodePadX
+			if w := 2*(labelWidth+m.clusterPad) + 1; w > item.w {
+				item.w = w
+			}
+			item.h = item.sub.h + m.clusterTop + m.clusterPad
+		} else {
+			item.w = textWidth(item.lines)*m.charWidth + 2*m.nodePadX
+			item.h = len(item.lines)*m.lineHeight + 2*m.nodePadY
+		}
+		box.items = append(box.items, item)
+		box.byNode[n] = item
+	}
+	for _, item := range box.items {
+		for i, next := range item.node.Outputs {
+			if to, ok := box.byNode[next]; ok && next != nil {
+				box.edges = append(box.edges, &layoutEdge{from: item, to: to, port: i})
+			}
+		}
+	}
+
+	box.assignLayers()
+	box.addDummies(m)
+	box.orderLayers()
+	box.assignCoordinates(m)
+	return box
+}
+
+// assignLayers puts each item one layer below the lowest item that has an
+// edge to it. Edges that would form a cycle are ignored.
+func (self *layoutBox) assignLayers() {
+	preds := make(map[*layoutItem][]*layoutItem)
+	for _, edge := range self.edges {
+		preds[edge.to] = append(preds[edge.to], edge.from)
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*layoutItem]int)
+	var visit func(item *layoutItem)
+	visit = func(item *layoutItem) {
+		state[item] = visiting
+		item.layer = 0
+		for _, prev := range preds[item] {
+			if state[prev] == unvisited {
+				visit(prev)
+			}
+			if state[prev] == visited && prev.layer+1 > item.layer {
+				item.layer = prev.layer + 1
+			}
+		}
+		state[item] = visited
+	}
+	for _, item := range self.items {
+		if state[item] == unvisited {
+			visit(item)
+		}
+	}
+}
+
+// addDummies adds placeholders to the layers in between the ends of edges
+// that skip over layers, and fills in 'layers'.
+func (self *layoutBox) addDummies(m layoutMetrics) {
+	numLayers := 0
+	for _, item := range self.items {
+		if item.layer+1 > numLayers {
+			numLayers = item.layer + 1
+		}
+	}
+	self.layers = make([][]*layoutItem, numLayers)
+	for _, item := range self.items {
+		self.layers[item.layer] = append(self.layers[item.layer], item)
+	}
+	for _, edge := range self.edges {
+		for layer := edge.from.layer + 1; layer < edge.to.layer; layer++ {
+			dummy := &layoutItem{dummy: true, layer: layer, w: m.dummyWidth}
+			edge.via = append(edge.via, dummy)
+			self.layers[layer] = append(self.layers[layer], dummy)
+		}
+	}
+}
+
+// orderLayers sorts the items in each layer to reduce the number of edges
+// that cross, by moving each item toward the average position of its
+// neighbors in the layer above (or below).
+func (self *layoutBox) orderLayers() {
+	above := make(map[*layoutItem][]*layoutItem)
+	below := make(map[*layoutItem][]*layoutItem)
+	self.above = above
+	for _, edge := range self.edges {
+		chain := append([]*layoutItem{edge.from}, edge.via...)
+		chain = append(chain, edge.to)
+		for i := 1; i < len(chain); i++ {
+			prev, next := chain[i-1], chain[i]
+			if next.layer == prev.layer+1 {
+				below[prev] = append(below[prev], next)
+				above[next] = append(above[next], prev)
+			}
+		}
+	}
+	index := func() {
+		for _, layer := range self.layers {
+			for i, item := range layer {
+				item.pos = float64(i)
+			}
+		}
+	}
+	sortLayer := func(layer []*layoutItem, neighbors map[*layoutItem][]*layoutItem) {
+		order := make(map[*layoutItem]float64, len(layer))
+		for i, item := range layer {
+			order[item] = float64(i)
+			if len(neighbors[item]) > 0 {
+				sum := 0.0
+				for _, n := range neighbors[item] {
+					sum += n.pos
+				}
+				order[item] = sum / float64(len(neighbors[item]))
+			}
+		}
+		sort.SliceStable(layer, func(i, j int) bool {
+			return order[layer[i]] < order[layer[j]]
+		})
+		for i, item := range layer {
+			item.pos = float64(i)
+		}
+	}
+	index()
+	for sweep := 0; sweep < 4; sweep++ {
+		for i := 1; i < len(self.layers); i++ {
+			sortLayer(self.layers[i], above)
+		}
+		for i := len(self.layers) - 2; i >= 0; i-- {
+			sortLayer(self.layers[i], below)
+		}
+	}
+}
+
+// assignCoordinates positions the items in each layer side by side. Each
+// layer starts out centered, and then the items are moved to sit under the
+// items that have edges to them, as long as they don't overlap.
+func (self *layoutBox) assignCoordinates(m layoutMetrics) {
+	y := 0
+	for i, layer := range self.layers {
+		if i > 0 {
+			y += m.vGap
+		}
+		height := 0
+		width := 0
+		for j, item := range layer {
+			if item.h > height {
+				height = item.h
+			}
+			if j > 0 {
+				width += m.hGap
+			}
+			width += item.w
+		}
+		if width > self.w {
+			self.w = width
+		}
+		for _, item := range layer {
+			item.y = y
+			if item.dummy {
+				item.h = height
+			}
+		}
+		y += height
+	}
+	self.h = y
+	for _, layer := range self.layers {
+		width := -m.hGap
+		for _, item := range layer {
+			width += item.w + m.hGap
+		}
+		x := (self.w - width) / 2
+		for _, item := range layer {
+			item.x = x
+			x += item.w + m.hGap
+		}
+	}
+	for i := 1; i < len(self.layers); i++ {
+		for j, item := range self.layers[i] {
+			if above := self.above[item]; len(above) > 0 {
+				sum := 0
+				for _, prev := range above {
+					sum += prev.centerX()
+				}
+				item.x = sum/len(above) - item.w/2
+			}
+			if j > 0 {
+				prev := self.layers[i][j-1]
+				if min := prev.x + prev.w + m.hGap; item.x < min {
+					item.x = min
+				}
+			}
+		}
+	}
+	// Shift everything over so that nothing is off the left edge, and
+	// resize the box to fit
+	left, right := 0, 0
+	for _, layer := range self.layers {
+		for _, item := range layer {
+			if item.x < left {
+				left = item.x
+			}
+			if item.x+item.w > right {
+				right = item.x + item.w
+			}
+		}
+	}
+	for _, layer := range self.layers {
+		for _, item := range layer {
+			item.x -= left
+		}
+	}
+	self.w = right - left
+}
+
+// place moves the layout so that its top left corner is at (x, y), and
+// makes the coordinates of all items absolute.
+func (self *layoutBox) place(x, y int, m layoutMetrics) {
+	for _, layer := range self.layers {
+		for _, item := range layer {
+			item.x += x
+			item.y += y
+			if item.sub != nil {
+				item.sub.place(item.x+(item.w-item.sub.w)/2, item.y+m.clusterTop, m)
+			}
+		}
+	}
+}
+
+// exitItem returns the item that edges leaving 'item' start from. For a
+// subgraph, that's the subgraph's Sink.
+func exitItem(item *layoutItem) *layoutItem {
+	for item.sub != nil && item.sub.byNode[item.sub.graph.Sink] != nil {
+		item = item.sub.byNode[item.sub.graph.Sink]
+	}
+	return item
+}
+
+// entryItem returns the item that edges going into 'item' end at. For a
+// subgraph, that's the subgraph's Source.
+func entryItem(item *layoutItem) *layoutItem {
+	for item.sub != nil && item.sub.byNode[item.sub.graph.Source] != nil {
+		item = item.sub.byNode[item.sub.graph.Source]
+	}
+	return item
+}
+
+type point struct {
+	x, y int
+}
+
+// layoutRoute is the path of an edge through a placed layout. The path
+// only has horizontal and vertical segments.
+type layoutRoute struct {
+	edge   *layoutEdge
+	from   *layoutItem
+	to     *layoutItem
+	label  string
+	weight int
+	points []point
+}
+
+// routes finds the paths for all of the edges in the layout, including the
+// edges inside of subgraphs. Edges leave from the bottom of a box and enter
+// at the top. If a box has several edges, they are spread out along its
+// side, ordered so that they don't cross each other.
+func (self *layoutBox) routes(m layoutMetrics, style *graphStyle) []*layoutRoute {
+	routes := make([]*layoutRoute, 0, len(self.edges))
+	var collect func(box *layoutBox)
+	collect = func(box *layoutBox) {
+		for _, item := range box.items {
+			if item.sub != nil {
+				collect(item.sub)
+			}
+		}
+		for _, edge := range box.edges {
+			routes = append(routes, &layoutRoute{edge: edge, from: exitItem(edge.from),
+				to: entryItem(edge.to), label: style.edgeLabel(edge.from.node, edge.port),
+				weight: style.edgeWeight(edge.from.node, edge.port)})
+		}
+	}
+	collect(self)
+
+	// Spread out the ends of the edges along the sides of the boxes
+	exits := make(map[*layoutItem][]*layoutRoute)
+	entries := make(map[*layoutItem][]*layoutRoute)
+	for _, route := range routes {
+		exits[route.from] = append(exits[route.from], route)
+		entries[route.to] = append(entries[route.to], route)
+	}
+	exitX := make(map[*layoutRoute]int)
+	entryX := make(map[*layoutRoute]int)
+	spread := func(item *layoutItem, group []*layoutRoute, key func(*layoutRoute) int, result map[*layoutRoute]int) {
+		sort.SliceStable(group, func(i, j int) bool {
+			return key(group[i]) < key(group[j])
+		})
+		for i, route := range group {
+			result[route] = item.x + (i+1)*item.w/(len(group)+1)
+		}
+	}
+	for item, group := range exits {
+		spread(item, group, func(r *layoutRoute) int {
+			if len(r.edge.via) > 0 {
+				return r.edge.via[0].centerX()
+			}
+			return r.edge.to.centerX()
+		}, exitX)
+	}
+	for item, group := range entries {
+		spread(item, group, func(r *layoutRoute) int {
+			if len(r.edge.via) > 0 {
+				return r.edge.via[len(r.edge.via)-1].centerX()
+			}
+			return r.edge.from.centerX()
+		}, entryX)
+	}
+
+	for _, route := range routes {
+		x := exitX[route]
+		y := route.from.y + route.from.h - m.inset
+		route.points = []point{{x, y}}
+		hops := append(append([]*layoutItem{}, route.edge.via...), route.edge.to)
+		for i, hop := range hops {
+			turn := hop.y - (m.vGap+1)/2
+			nextX := hop.centerX()
+			if i == len(hops)-1 {
+				nextX = entryX[route]
+			}
+			route.points = append(route.points, point{x, turn}, point{nextX, turn})
+			x = nextX
+			if hop.dummy {
+				route.points = append(route.points, point{x, hop.y + hop.h - m.inset})
+			}
+		}
+		route.points = append(route.points, point{x, route.to.y - m.inset})
+	}
+	return routes
+}
+
+// walkItems calls 'fn' for every item in the layout, including the items
+// in subgraphs. Boxes are visited before the items inside of them.
+func (self *layoutBox) walkItems(fn func(item *layoutItem)) {
+	for _, item := range self.items {
+		fn(item)
+		if item.sub != nil {
+			item.sub.walkItems(fn)
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package pike
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // Merge creates a Node that merges multiple input streams into a single
 // output. The ordering of the edges is preserved (i.e. all files from the
 // first edge will preceed files from the second input edge).
 func Merge() *Node {
-	f := func(in, out []chan File) {
+	f := func(ctx context.Context, in, out []chan File) error {
 		o := out[0]
 		for _, c := range in {
 			for file := range c {
@@ -14,6 +17,7 @@ func Merge() *Node {
 			}
 		}
 		close(out[0])
+		return nil
 	}
 	runner := FxnRunnable(f)
 	return NewNode("merge", 2, -1, 1, 1, runner)
@@ -22,7 +26,7 @@ func Merge() *Node {
 // MergeUnordered creates a Node that merges multiple input streams into a
 // single output. There are no ordering guarantees across edges.
 func MergeUnordered() *Node {
-	f := func(in, out []chan File) {
+	f := func(ctx context.Context, in, out []chan File) error {
 		allClosed := false
 		for !allClosed {
 			allClosed = true
@@ -40,6 +44,7 @@ func MergeUnordered() *Node {
 			time.Sleep(10 * time.Millisecond)
 		}
 		close(out[0])
+		return nil
 	}
 	runner := FxnRunnable(f)
 	return NewNode("merge", 2, -1, 1, 1, runner)
@@ -2,21 +2,41 @@
 package pike
 
 import (
+	"context"
 	"fmt"
 	"strings"
 )
 
 // Node is a component of the asset graphs. Each node performs an operation on
 // the files as they pass through, and can be connected to other Nodes.
+// OutputPorts holds optional names for the outputs, in order. Outputs may
+// contain nil entries for ports that were skipped when connecting with
+// Port.
 type Node struct {
-	Name       string
-	Inputs     []*Node
-	Outputs    []*Node
-	MinInputs  int
-	MaxInputs  int
-	MinOutputs int
-	MaxOutputs int
-	Runner     Runnable
+	Name        string
+	Inputs      []*Node
+	Outputs     []*Node
+	MinInputs   int
+	MaxInputs   int
+	MinOutputs  int
+	MaxOutputs  int
+	Runner      Runnable
+	OutputPorts []string
+	// External programs that the Node runs, checked by Graph.Preflight
+	Tools []Tool
+	// Options describes how the Node was configured (e.g. the destination
+	// of a Write). Saved state is discarded when it changes (see
+	// SetStateDir), so Nodes should set it to anything that changes what
+	// they output.
+	Options string
+	// problems found while connecting the Node, reported by Graph.validate
+	problems []string
+}
+
+// Port is a named output of a Node.
+type Port struct {
+	Node *Node
+	Name string
 }
 
 // Nodeable is an interface that can be converted to a Node. This is useful for
@@ -35,20 +55,61 @@ func nodeIndex(nodes []*Node, node *Node) int {
 	return -1
 }
 
+// nthIndex returns the index of the k'th time (counting from 0) that a Node
+// appears in a slice, or -1 if it appears fewer times.
+func nthIndex(nodes []*Node, node *Node, k int) int {
+	for i, v := range nodes {
+		if v == node {
+			if k == 0 {
+				return i
+			}
+			k--
+		}
+	}
+	return -1
+}
+
+// inputIndex returns the index in the Inputs of the Node connected to output
+// 'port' of 'from' that the edge arrives at. If two Nodes are connected more
+// than once (e.g. two ports of one Node piped into a Merge), the k'th of those
+// outputs pairs with the k'th of the inputs.
+func inputIndex(from *Node, port int) int {
+	to := from.Outputs[port]
+	k := 0
+	for _, prev := range from.Outputs[:port] {
+		if prev == to {
+			k++
+		}
+	}
+	return nthIndex(to.Inputs, from, k)
+}
+
 func (node *Node) String() string {
 	return fmt.Sprintf("Node(%q)", node.Name)
 }
 
 // NewNode constructs a Node struct.
 func NewNode(name string, minIn, maxIn, minOut, maxOut int, runner Runnable) *Node {
-	return &Node{name, nil, nil, minIn, maxIn, minOut, maxOut, runner}
+	return &Node{name, nil, nil, minIn, maxIn, minOut, maxOut, runner, nil, nil, "", nil}
+}
+
+// NewPortNode constructs a Node with named outputs. The node may have up to
+// one output per port name.
+func NewPortNode(name string, minIn, maxIn, minOut int, ports []string, runner Runnable) *Node {
+	node := NewNode(name, minIn, maxIn, minOut, len(ports), runner)
+	node.OutputPorts = ports
+	return node
 }
 
 // NewFuncNode constructs a simple 1-input, 1-output node from a function.
-func NewFuncNode(name string, run func(in, out chan File)) *Node {
-	f := func(in, out []chan File) {
-		run(in[0], out[0])
+// If the function returns early, any remaining input will be discarded.
+func NewFuncNode(name string, run func(ctx context.Context, in, out chan File) error) *Node {
+	f := func(ctx context.Context, in, out []chan File) error {
+		err := run(ctx, in[0], out[0])
 		close(out[0])
+		for _ = range in[0] {
+		}
+		return err
 	}
 	runner := FxnRunnable(f)
 	return NewNode(name, 1, 1, 1, 1, runner)
@@ -57,8 +118,12 @@ func NewFuncNode(name string, run func(in, out chan File)) *Node {
 // Create a deep copy of a Node. Note that this will reset the Inputs and
 // Outputs.
 func (node *Node) Copy() *Node {
-	return NewNode(node.Name, node.MinInputs, node.MaxInputs, node.MinOutputs,
+	newNode := NewNode(node.Name, node.MinInputs, node.MaxInputs, node.MinOutputs,
 		node.MaxOutputs, node.Runner.Copy())
+	newNode.OutputPorts = node.OutputPorts
+	newNode.Tools = node.Tools
+	newNode.Options = node.Options
+	return newNode
 }
 
 // For Node this is a no-op
@@ -66,46 +131,112 @@ func (self *Node) Node() *Node {
 	return self
 }
 
-// Walk this node and all parents.
+// Walk this node and all parents. Each node is only sent once, even if
+// there are multiple paths to it.
 func (n *Node) WalkUp(out chan *Node) {
-	n._walkUp(out)
+	n._walk(out, func(n *Node) []*Node { return n.Inputs }, make(map[*Node]bool))
 	close(out)
 }
-func (n *Node) _walkUp(out chan *Node) {
-	out <- n
-	for _, parent := range n.Inputs {
-		parent._walkUp(out)
-	}
-}
 
-// Walk this node and all children.
+// Walk this node and all children. Each node is only sent once, even if
+// there are multiple paths to it.
 func (n *Node) Walk(out chan *Node) {
-	n._walk(out)
+	n._walk(out, func(n *Node) []*Node { return n.Outputs }, make(map[*Node]bool))
 	close(out)
 }
-func (n *Node) _walk(out chan *Node) {
+
+func (n *Node) _walk(out chan *Node, next func(*Node) []*Node, seen map[*Node]bool) {
+	if seen[n] {
+		return
+	}
+	seen[n] = true
 	out <- n
-	for _, child := range n.Outputs {
-		child._walk(out)
+	for _, child := range next(n) {
+		if child != nil {
+			child._walk(out, next, seen)
+		}
 	}
 }
 
-// Pipe creates a connection from one Node to a Node or Graph.
+// Pipe creates a connection from one Node to a Node or Graph. It uses the
+// first output that is not already connected.
 func (n1 *Node) Pipe(nodeMaker Nodeable) *Node {
 	n2 := nodeMaker.Node()
-	n1.Outputs = append(n1.Outputs, n2)
+	i := nodeIndex(n1.Outputs, nil)
+	if i == -1 {
+		n1.Outputs = append(n1.Outputs, n2)
+	} else {
+		n1.Outputs[i] = n2
+	}
 	n2.Inputs = append(n2.Inputs, n1)
 	return n2
 }
 
+// Port returns the named output of a Node. The name is checked when the
+// Graph is validated.
+func (n *Node) Port(name string) *Port {
+	return &Port{n, name}
+}
+
+// PortName returns the name of the i'th output, or "" if it has none.
+func (n *Node) PortName(i int) string {
+	if i >= 0 && i < len(n.OutputPorts) {
+		return n.OutputPorts[i]
+	}
+	return ""
+}
+
+// Pipe creates a connection from a named output to a Node or Graph. Any
+// earlier outputs that are not connected will have their files discarded.
+func (port *Port) Pipe(nodeMaker Nodeable) *Node {
+	n1 := port.Node
+	n2 := nodeMaker.Node()
+	i := indexOf(n1.OutputPorts, port.Name)
+	if i == -1 {
+		n1.problems = append(n1.problems, fmt.Sprintf(
+			"%s has no output port %q (ports: %s)", n1.Name, port.Name,
+			strings.Join(n1.OutputPorts, ", ")))
+		return n2
+	}
+	for len(n1.Outputs) <= i {
+		n1.Outputs = append(n1.Outputs, nil)
+	}
+	if n1.Outputs[i] != nil {
+		n1.problems = append(n1.problems, fmt.Sprintf(
+			"%s output %q is connected to both %s and %s", n1.Name, port.Name,
+			n1.Outputs[i].Name, n2.Name))
+		return n2
+	}
+	n1.Outputs[i] = n2
+	n2.Inputs = append(n2.Inputs, n1)
+	return n2
+}
+
+func indexOf(strs []string, str string) int {
+	for i, s := range strs {
+		if s == str {
+			return i
+		}
+	}
+	return -1
+}
+
 // Dot returns the dot representation of this node and all outbound edges.
+// Edges are labeled with the port name or, if the node has multiple unnamed
+// outputs, the output index. The node IDs are memory addresses, so use
+// Graph.Dot if you need the same output every time.
 func (self *Node) Dot(indent string) string {
 	lines := make([]string, 0, 2)
 
 	lines = append(lines, fmt.Sprintf("%s\"%p\" [label=%q];", indent, self, self.Name))
 	for i, next := range self.Outputs {
+		if next == nil {
+			continue
+		}
 		dotEdge := fmt.Sprintf("%s\"%p\" -> \"%p\"", indent, self, next)
-		if len(self.Outputs) > 1 {
+		if port := self.PortName(i); port != "" {
+			dotEdge += fmt.Sprintf(" [label=%q]", port)
+		} else if len(self.Outputs) > 1 {
 			dotEdge += fmt.Sprintf(" [label=\"%d\"]", i)
 		}
 		lines = append(lines, dotEdge)
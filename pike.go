@@ -1,25 +1,58 @@
 package pike
 
 import (
+	"context"
 	"flag"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
 	"time"
 
 	"github.com/stevearc/pike/plog"
 )
 
+// Start parses the command line flags and runs the Graphs. The Graphs are
+// created by 'graphMaker' and by the pipeline file passed with -f, if any.
+// 'graphMaker' may be nil if all Graphs come from a pipeline file.
 func Start(graphMaker func(watch bool) []*Graph) {
 	var watch bool
+	var pipelineFile string
+	var stateDir string
+	var serveAddr string
+	var serveDir string
+	var pruneCache string
+	var cacheSize string
 	var jsonFile string
 	var prettyJson bool
 	var interval int
 	var level string
+	var showStats bool
+	var statsFile string
+	var traceFile string
+	var graphReport string
+	var hangTimeout time.Duration
+	var toolTimeout time.Duration
+	var toolRetries int
 
 	flag.BoolVar(&watch, "w", false, "Rerun graphs constantly (should be used with ChangeFilters)")
 	flag.StringVar(&jsonFile, "json", "", "The output file for json data (if using Json nodes)")
 	flag.BoolVar(&prettyJson, "p", false, "Pretty-format the json data")
-	flag.IntVar(&interval, "i", 200, "If using -w, sets the sleep interval between runs (in milliseconds)")
+	flag.IntVar(&interval, "i", 200, "If using -w, sets how long to wait for file changes to settle before rerunning, or the sleep interval between runs if polling (in milliseconds)")
 	flag.StringVar(&level, "l", "info", "Set the log level (debug, info, warn, error, fatal)")
+	flag.StringVar(&pipelineFile, "f", "", "Load graphs from a JSON pipeline file")
+	flag.StringVar(&serveAddr, "serve", "", "If using -w, serve the output directory on this address (e.g. :8080) and reload browsers when files are written")
+	flag.StringVar(&serveDir, "serve-dir", "build", "The directory to serve with -serve")
+	flag.StringVar(&pruneCache, "prune-cache", "", "Evict the least recently used entries from this TransformCache directory and exit")
+	flag.StringVar(&cacheSize, "cache-size", "256M", "If using -prune-cache, the size to shrink the cache to")
+	flag.StringVar(&stateDir, "state", "", "Save the state of ChangeFilters and ChangeCaches in this directory (e.g. .pike) so later runs only process changed files")
+	flag.BoolVar(&showStats, "stats", false, "Print a table of how long each node took and how many files it processed")
+	flag.StringVar(&statsFile, "stats-json", "", "Write the node stats to this file as JSON")
+	flag.StringVar(&traceFile, "trace", "", "Write a trace of the build to this file, in the Chrome Trace Event format")
+	flag.StringVar(&graphReport, "graph-report", "", "After each build, draw the graphs to this file (.svg, .txt, .dot, or any graphviz format) annotated with the node stats")
+	flag.DurationVar(&hangTimeout, "hang-timeout", 5*time.Minute, "Cancel a build if no files move for this long (e.g. 30s) while no external tools are running, since it is probably deadlocked. 0 disables this.")
+	flag.DurationVar(&toolTimeout, "tool-timeout", 0, "Kill an external tool (e.g. lessc) if it runs for longer than this on one file (e.g. 30s). 0 means no limit.")
+	flag.IntVar(&toolRetries, "tool-retries", 0, "Run an external tool again up to this many times if it fails, waiting longer before each retry")
 
 	flag.Parse()
 
@@ -29,6 +62,12 @@ func Start(graphMaker func(watch bool) []*Graph) {
 	if prettyJson {
 		SetJsonPretty(true)
 	}
+	if stateDir != "" {
+		SetStateDir(stateDir)
+	}
+	SetHangTimeout(hangTimeout)
+	SetToolTimeout(toolTimeout)
+	SetToolRetries(toolRetries, time.Second)
 
 	switch strings.ToLower(level) {
 	case "debug":
@@ -47,11 +86,92 @@ func Start(graphMaker func(watch bool) []*Graph) {
 		plog.Fatal("Unrecognized log level %q", level)
 	}
 
-	graphs := graphMaker(watch)
+	if pruneCache != "" {
+		maxBytes, err := ParseSize(cacheSize)
+		if err != nil {
+			plog.Fatal("%v", err)
+		}
+		if err = NewTransformCache(pruneCache, maxBytes).Prune(maxBytes); err != nil {
+			plog.Fatal("%v", err)
+		}
+		return
+	}
+
+	graphs := make([]*Graph, 0, 10)
+	if graphMaker != nil {
+		graphs = append(graphs, graphMaker(watch)...)
+	}
+	if pipelineFile != "" {
+		pipelineGraphs, err := LoadPipelineFile(pipelineFile)
+		if err != nil {
+			plog.Fatal("%v", err)
+		}
+		graphs = append(graphs, pipelineGraphs...)
+	}
+
+	// External tools run in their own process groups, so they don't get
+	// the signal from Ctrl-C. Cancelling the build kills them instead.
+	SetToolProcessGroups(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, stopSignals...)
+	go func() {
+		sig := <-signals
+		// A second signal kills pike right away
+		signal.Stop(signals)
+		plog.Warn("Got %v, stopping", sig)
+		cancel()
+	}()
+	var tracer *Tracer
+	if traceFile != "" {
+		tracer = NewTracer()
+		ctx = tracer.Trace(ctx)
+	}
+	report := func(stats *RunStats) {
+		stats.Sort()
+		if showStats {
+			plog.Info("Node stats:\n%s", stats.Table())
+		}
+		if statsFile != "" {
+			if err := stats.WriteJson(statsFile); err != nil {
+				plog.Error("Error writing stats to %q", statsFile)
+				plog.Exc(err)
+			}
+		}
+		if tracer != nil {
+			if err := tracer.WriteFile(traceFile); err != nil {
+				plog.Error("Error writing trace to %q", traceFile)
+				plog.Exc(err)
+			}
+			// With -w, each build gets a fresh trace
+			tracer.Reset()
+		}
+		if graphReport != "" {
+			if err := RenderReport(graphReport, graphs, stats); err != nil {
+				plog.Error("Error writing graph report to %q", graphReport)
+				plog.Exc(err)
+			}
+		}
+	}
 
 	if watch {
-		WatchAll(graphs, time.Duration(interval)*time.Millisecond)
-	} else {
-		RunAll(graphs)
+		if serveAddr != "" {
+			server := NewDevServer(serveDir)
+			go func() {
+				plog.Info("Serving %q on %s", serveDir, serveAddr)
+				if err := http.ListenAndServe(serveAddr, server); err != nil {
+					plog.Fatal("%v", err)
+				}
+			}()
+		}
+		watchAll(ctx, graphs, time.Duration(interval)*time.Millisecond, report)
+		return
+	}
+	stats, err := RunAllStats(ctx, graphs)
+	report(stats)
+	if err != nil {
+		errs := err.(Errors)
+		plog.Fatal("Build failed with %d error(s)", len(errs))
 	}
 }
@@ -0,0 +1,143 @@
+package pike
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Pipeline is the description of a set of Graphs, as read from a pipeline
+// file. For example:
+//
+//	{"graphs": [{
+//	  "name": "app.less",
+//	  "nodes": [
+//	    {"name": "src", "type": "glob", "args": {"root": "app/src", "patterns": ["*.less"]}},
+//	    {"name": "less", "type": "less"},
+//	    {"name": "out", "type": "write", "args": {"dest": "build"}}
+//	  ],
+//	  "edges": [
+//	    {"from": "src", "to": "less"},
+//	    {"from": "less", "to": "out"}
+//	  ]
+//	}]}
+//
+// The "type" of each node must be registered with Register. Edges are
+// connected in the order they are listed, so the first edge leaving a node
+// is connected to its first output. An edge can also use a named output by
+// setting "port" (e.g. {"from": "coffee", "port": "map", "to": "maps"}).
+type Pipeline struct {
+	Graphs []PipelineGraph `json:"graphs"`
+}
+
+// PipelineGraph describes a single Graph in a Pipeline.
+type PipelineGraph struct {
+	Name  string         `json:"name"`
+	Nodes []PipelineNode `json:"nodes"`
+	Edges []PipelineEdge `json:"edges"`
+}
+
+// PipelineNode describes a single Node in a PipelineGraph. If Name is
+// empty, it defaults to the Type. Names must be unique within a graph.
+type PipelineNode struct {
+	Name string          `json:"name"`
+	Type string          `json:"type"`
+	Args json.RawMessage `json:"args"`
+}
+
+// PipelineEdge describes a connection between two named Nodes. Port is the
+// optional name of the output port on the From node.
+type PipelineEdge struct {
+	From string `json:"from"`
+	Port string `json:"port"`
+	To   string `json:"to"`
+}
+
+// LoadPipeline reads a JSON pipeline description and builds the Graphs in
+// it.
+func LoadPipeline(r io.Reader) ([]*Graph, error) {
+	var pipeline Pipeline
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&pipeline); err != nil {
+		return nil, err
+	}
+	return pipeline.Build()
+}
+
+// LoadPipelineFile reads a JSON pipeline file and builds the Graphs in it.
+func LoadPipelineFile(filename string) ([]*Graph, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	graphs, err := LoadPipeline(file)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %v", filename, err))
+	}
+	return graphs, nil
+}
+
+// Build constructs the Graphs described by the Pipeline.
+func (self *Pipeline) Build() ([]*Graph, error) {
+	graphs := make([]*Graph, 0, len(self.Graphs))
+	for _, desc := range self.Graphs {
+		graph, err := desc.Build()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("graph %q: %v", desc.Name, err))
+		}
+		graphs = append(graphs, graph)
+	}
+	return graphs, nil
+}
+
+// Build constructs the Graph described by the PipelineGraph.
+func (self *PipelineGraph) Build() (*Graph, error) {
+	nodes := make(map[string]*Node, len(self.Nodes))
+	ordered := make([]*Node, 0, len(self.Nodes))
+	for _, desc := range self.Nodes {
+		name := desc.Name
+		if name == "" {
+			name = desc.Type
+		}
+		if _, ok := nodes[name]; ok {
+			return nil, errors.New(fmt.Sprintf("duplicate node name %q", name))
+		}
+		node, err := NewRegisteredNode(desc.Type, desc.Args)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("node %q: %v", name, err))
+		}
+		// Catch bad arguments now, rather than when the Graph runs
+		if len(node.problems) > 0 {
+			return nil, errors.New(fmt.Sprintf("node %q: %s", name,
+				strings.Join(node.problems, "; ")))
+		}
+		node.Name = name
+		nodes[name] = node
+		ordered = append(ordered, node)
+	}
+	for _, edge := range self.Edges {
+		from, ok := nodes[edge.From]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("edge %s -> %s: unknown node %q",
+				edge.From, edge.To, edge.From))
+		}
+		to, ok := nodes[edge.To]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("edge %s -> %s: unknown node %q",
+				edge.From, edge.To, edge.To))
+		}
+		if edge.Port != "" {
+			from.Port(edge.Port).Pipe(to)
+		} else {
+			from.Pipe(to)
+		}
+	}
+	graph := NewGraph(self.Name)
+	graph.Add(ordered...)
+	return graph, nil
+}
@@ -0,0 +1,21 @@
+package pike
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadPipelineBadArgs(t *testing.T) {
+	for _, node := range []string{
+		`{"name": "names", "type": "rename", "args": {"format": "{{.Barename"}}`,
+		`{"name": "names", "type": "exec", "args": {"args": []}}`,
+	} {
+		_, err := LoadPipeline(strings.NewReader(`{"graphs": [{"name": "app", "nodes": [` +
+			node + `]}]}`))
+		if err == nil {
+			t.Errorf("Expected an error for %s", node)
+		} else if !strings.Contains(err.Error(), `graph "app": node "names": `) {
+			t.Errorf("Expected the error to name the node, got %q", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package pike
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Tool is an external program that a Node runs.
+type Tool struct {
+	Name string
+	// How to install the program (e.g. "npm install -g less")
+	Install string
+	// If not "", the lowest version that works (e.g. "2.5"). The version is
+	// read from the output of "<Name> --version".
+	MinVersion string
+}
+
+// The tools that OsCommandRunner has already found, so that watching a
+// Graph doesn't check them again on every run
+var foundTools = struct {
+	lock  sync.Mutex
+	found map[Tool]bool
+}{found: make(map[Tool]bool)}
+
+// Preflight checks that all of the Tools needed by the Nodes in the Graph
+// and its subgraphs are installed and new enough. All of the problems are
+// reported in a single error, with how to install each missing Tool. The
+// Tools are found with the CommandRunner from the context. RunContext calls
+// this, so a Graph with a missing Tool fails before it processes any files.
+func (graph *Graph) Preflight(ctx context.Context) error {
+	runner := commandRunnerFrom(ctx)
+	problems := make([]string, 0)
+	checked := make(map[Tool]bool)
+	var check func(g *Graph)
+	check = func(g *Graph) {
+		for _, n := range g.nodes {
+			for _, tool := range n.Tools {
+				if checked[tool] {
+					continue
+				}
+				checked[tool] = true
+				if err := checkTool(ctx, runner, tool); err != nil {
+					problem := fmt.Sprintf("%s (needed by %s): %v", tool.Name, n.Name, err)
+					if tool.Install != "" {
+						problem += fmt.Sprintf(". Install it with %q", tool.Install)
+					}
+					problems = append(problems, problem)
+				}
+			}
+			if runner, ok := n.Runner.(*GraphRunnable); ok {
+				check(runner.Graph)
+			}
+		}
+	}
+	check(graph)
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("missing tools for graph %q:\n  %s", graph.Name,
+			strings.Join(problems, "\n  ")))
+	}
+	return nil
+}
+
+func checkTool(ctx context.Context, runner CommandRunner, tool Tool) error {
+	_, cache := runner.(OsCommandRunner)
+	if cache {
+		foundTools.lock.Lock()
+		found := foundTools.found[tool]
+		foundTools.lock.Unlock()
+		if found {
+			return nil
+		}
+	}
+	if _, err := runner.LookPath(tool.Name); err != nil {
+		return errors.New("not found in PATH")
+	}
+	if tool.MinVersion != "" {
+		result, err := runner.Run(ctx, &Command{Name: tool.Name, Args: []string{"--version"}})
+		if err != nil {
+			return fmt.Errorf("could not check the version: %v", err)
+		}
+		version := parseVersion(string(result.Stdout) + string(result.Stderr))
+		if version == "" {
+			return errors.New(fmt.Sprintf("could not find the version in the output of %q",
+				tool.Name+" --version"))
+		}
+		if compareVersions(version, tool.MinVersion) < 0 {
+			return errors.New(fmt.Sprintf("version %s is installed, but %s or newer is needed",
+				version, tool.MinVersion))
+		}
+	}
+	if cache {
+		foundTools.lock.Lock()
+		foundTools.found[tool] = true
+		foundTools.lock.Unlock()
+	}
+	return nil
+}
+
+var versionRegex = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// parseVersion finds the first version number in the output of a program
+// (e.g. "3.9.0" in "lessc 3.9.0 (Less Compiler) [JavaScript]").
+func parseVersion(output string) string {
+	return versionRegex.FindString(output)
+}
+
+// compareVersions compares two dotted version numbers, and returns -1, 0,
+// or 1 if 'a' is older, the same, or newer than 'b'. Missing parts count
+// as 0, so "2" is the same as "2.0.0".
+func compareVersions(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			y, _ = strconv.Atoi(bParts[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
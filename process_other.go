@@ -0,0 +1,22 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package pike
+
+import (
+	"os"
+	"os/exec"
+)
+
+// stopSignals are the signals that make Start cancel the build.
+var stopSignals = []os.Signal{os.Interrupt}
+
+// newProcessGroup does nothing on this platform, so only the command itself
+// will be killed.
+func newProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcess kills a command.
+func killProcess(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
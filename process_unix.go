@@ -0,0 +1,28 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package pike
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// stopSignals are the signals that make Start cancel the build.
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// newProcessGroup makes a command start in its own process group, so that
+// killProcess can also kill any processes that it starts (e.g. the node
+// process behind an npm tool's wrapper script). This also takes it out of
+// the terminal's process group, so Ctrl-C no longer reaches it directly,
+// which is why it is only used if SetToolProcessGroups is enabled.
+func newProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcess kills a command that was started with newProcessGroup, along
+// with the rest of its process group.
+func killProcess(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,218 @@
+package pike
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NodeFactory constructs a Node from the arguments given to it in a
+// pipeline file. 'args' will be nil if there were no arguments.
+type NodeFactory func(args json.RawMessage) (*Node, error)
+
+var registry = struct {
+	Lock      *sync.Mutex
+	Factories map[string]NodeFactory
+}{
+	&sync.Mutex{},
+	make(map[string]NodeFactory),
+}
+
+// Register makes a type of Node available to pipeline files under 'name'.
+// Packages that provide their own Nodes can call this from an init
+// function. It panics if the name is already registered.
+func Register(name string, factory NodeFactory) {
+	registry.Lock.Lock()
+	defer registry.Lock.Unlock()
+	if _, ok := registry.Factories[name]; ok {
+		panic(fmt.Sprintf("pike: node type %q is already registered", name))
+	}
+	registry.Factories[name] = factory
+}
+
+// NodeTypes returns the sorted names of all registered node types.
+func NodeTypes() []string {
+	registry.Lock.Lock()
+	defer registry.Lock.Unlock()
+	names := make([]string, 0, len(registry.Factories))
+	for name := range registry.Factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewRegisteredNode constructs a Node from a registered factory.
+func NewRegisteredNode(nodeType string, args json.RawMessage) (*Node, error) {
+	registry.Lock.Lock()
+	factory, ok := registry.Factories[nodeType]
+	registry.Lock.Unlock()
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown node type %q", nodeType))
+	}
+	return factory(args)
+}
+
+// DecodeArgs unmarshals the arguments for a NodeFactory into 'v'. Unknown
+// fields are treated as an error so that typos don't go unnoticed.
+func DecodeArgs(args json.RawMessage, v interface{}) error {
+	if len(args) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(args))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// simpleFactory creates a NodeFactory for a constructor that takes no
+// arguments.
+func simpleFactory(constructor func() *Node) NodeFactory {
+	return func(args json.RawMessage) (*Node, error) {
+		var empty struct{}
+		if err := DecodeArgs(args, &empty); err != nil {
+			return nil, err
+		}
+		return constructor(), nil
+	}
+}
+
+func init() {
+	Register("changecache", simpleFactory(ChangeCache))
+	Register("changefilter", simpleFactory(ChangeFilter))
+	Register("changewatcher", simpleFactory(ChangeWatcher))
+	Register("cleancss", simpleFactory(CleanCss))
+	Register("coffee", simpleFactory(Coffee))
+	Register("fanin", simpleFactory(FanIn))
+	Register("fingerprint", simpleFactory(Fingerprint))
+	Register("less", simpleFactory(Less))
+	Register("loadbalancer", simpleFactory(LoadBalancer))
+	Register("loadbalancer-unordered", simpleFactory(LoadBalancerUnordered))
+	Register("merge", simpleFactory(Merge))
+	Register("merge-unordered", simpleFactory(MergeUnordered))
+	Register("sink", simpleFactory(Sink))
+	Register("uglify", simpleFactory(Uglify))
+
+	Register("glob", func(args json.RawMessage) (*Node, error) {
+		var a struct {
+			Root     string   `json:"root"`
+			Patterns []string `json:"patterns"`
+		}
+		if err := DecodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		if len(a.Patterns) == 0 {
+			return nil, errors.New("glob requires at least one pattern")
+		}
+		for _, pattern := range a.Patterns {
+			if pattern == "" {
+				return nil, errors.New("glob patterns cannot be empty")
+			}
+		}
+		return Glob(a.Root, a.Patterns...), nil
+	})
+	Register("write", func(args json.RawMessage) (*Node, error) {
+		var a struct {
+			Dest string `json:"dest"`
+			Mode string `json:"mode"`
+		}
+		if err := DecodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		if a.Mode == "" {
+			return Write(a.Dest), nil
+		}
+		mode, err := strconv.ParseUint(a.Mode, 8, 32)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid file mode %q", a.Mode))
+		}
+		return WriteMode(a.Dest, os.FileMode(mode)), nil
+	})
+	Register("rename", func(args json.RawMessage) (*Node, error) {
+		var a struct {
+			Format string `json:"format"`
+		}
+		if err := DecodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		return Rename(a.Format), nil
+	})
+	Register("concat", func(args json.RawMessage) (*Node, error) {
+		var a struct {
+			Path string `json:"path"`
+		}
+		if err := DecodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		return Concat(a.Path), nil
+	})
+	Register("debug", func(args json.RawMessage) (*Node, error) {
+		var a struct {
+			Tag string `json:"tag"`
+		}
+		if err := DecodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		return Debug(a.Tag), nil
+	})
+	Register("exec", func(args json.RawMessage) (*Node, error) {
+		var a struct {
+			Name string `json:"name"`
+			ExecConfig
+			// Durations such as "30s"
+			Timeout    string `json:"timeout"`
+			RetryDelay string `json:"retry_delay"`
+		}
+		if err := DecodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		if a.Name == "" {
+			a.Name = "exec"
+		}
+		var err error
+		if a.Timeout != "" {
+			if a.ExecConfig.Timeout, err = time.ParseDuration(a.Timeout); err != nil {
+				return nil, err
+			}
+		}
+		if a.RetryDelay != "" {
+			if a.ExecConfig.RetryDelay, err = time.ParseDuration(a.RetryDelay); err != nil {
+				return nil, err
+			}
+		}
+		return Exec(a.Name, a.ExecConfig), nil
+	})
+	Register("json", func(args json.RawMessage) (*Node, error) {
+		var a struct {
+			Key string `json:"key"`
+		}
+		if err := DecodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		return Json(a.Key), nil
+	})
+	Register("serve", func(args json.RawMessage) (*Node, error) {
+		var a struct {
+			Addr string `json:"addr"`
+		}
+		if err := DecodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		return Serve(a.Addr), nil
+	})
+	Register("html2tc", func(args json.RawMessage) (*Node, error) {
+		var a struct {
+			Module string `json:"module"`
+			Prefix string `json:"prefix"`
+		}
+		if err := DecodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		return HtmlTemplateCache(a.Module, a.Prefix), nil
+	})
+}
@@ -2,10 +2,10 @@ package pike
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"path/filepath"
 	"text/template"
-
-	"github.com/stevearc/pike/plog"
 )
 
 // Rename creates a Node that renames the Files that pass through. 'format'
@@ -18,10 +18,11 @@ import (
 //   Ext     : .js
 func Rename(format string) *Node {
 	tmpl, err := template.New("rename").Parse(format)
-	if err != nil {
-		plog.Exc(err)
-	}
-	f := func(in, out chan File) {
+	f := func(ctx context.Context, in, out chan File) error {
+		if err != nil {
+			return err
+		}
+		var errs Errors
 		for file := range in {
 			base := filepath.Base(file.Name())
 			dir := filepath.Dir(file.Name())
@@ -45,12 +46,18 @@ func Rename(format string) *Node {
 			var buffer bytes.Buffer
 			err := tmpl.Execute(&buffer, data)
 			if err != nil {
-				plog.Exc(err)
+				errs.Add(FileError(file, err))
 			} else {
 				file.SetName(buffer.String())
 				out <- file
 			}
 		}
+		return errs.Err()
+	}
+	node := NewFuncNode("rename", f)
+	node.Options = fmt.Sprintf("%q", format)
+	if err != nil {
+		node.problems = append(node.problems, fmt.Sprintf("rename: %v", err))
 	}
-	return NewFuncNode("rename", f)
+	return node
 }
@@ -0,0 +1,329 @@
+package pike
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var svgMetrics = layoutMetrics{
+	charWidth:  7,
+	lineHeight: 16,
+	nodePadX:   12,
+	nodePadY:   8,
+	hGap:       24,
+	vGap:       40,
+	clusterPad: 12,
+	clusterTop: 28,
+	dummyWidth: 0,
+	inset:      0,
+}
+
+var textMetrics = layoutMetrics{
+	charWidth:  1,
+	lineHeight: 1,
+	nodePadX:   2,
+	nodePadY:   1,
+	hGap:       3,
+	vGap:       3,
+	clusterPad: 2,
+	clusterTop: 2,
+	dummyWidth: 1,
+	inset:      1,
+}
+
+// graphStyle controls what is drawn for each Node and edge of a Graph.
+// Edges are identified by the Node they leave and the index of the output.
+type graphStyle struct {
+	// The lines of text for a Node. Subgraphs only use the first line.
+	label     func(n *Node) []string
+	edgeLabel func(n *Node, port int) string
+	// How thick to draw an edge, from 1 to maxEdgeWeight
+	edgeWeight func(n *Node, port int) int
+	// Failed Nodes are drawn in red
+	failed func(n *Node) bool
+}
+
+const maxEdgeWeight = 6
+
+var defaultStyle = &graphStyle{
+	label:      nodeLabel,
+	edgeLabel:  portLabel,
+	edgeWeight: func(n *Node, port int) int { return 1 },
+	failed:     func(n *Node) bool { return false },
+}
+
+// nodeLabel is the Node's name, or the name of the Graph for a subgraph.
+func nodeLabel(n *Node) []string {
+	if runner, ok := n.Runner.(*GraphRunnable); ok {
+		return []string{runner.Graph.Name}
+	}
+	return []string{n.Name}
+}
+
+// portLabel is the label for an edge: the name of the output, or its index
+// if the Node has several unnamed outputs.
+func portLabel(n *Node, port int) string {
+	label := n.PortName(port)
+	if label == "" && len(n.Outputs) > 1 {
+		label = strconv.Itoa(port)
+	}
+	return label
+}
+
+// Svg draws the Graph as an SVG image. Graphs that are used as Nodes are
+// drawn as a box around their own Nodes. This does not require graphviz.
+func (self *Graph) Svg() string {
+	return self.svg(defaultStyle)
+}
+
+func (self *Graph) svg(style *graphStyle) string {
+	const margin = 10
+	m := svgMetrics
+	box := layoutGraph(self, m, style.label)
+	box.place(margin, margin, m)
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="monospace" font-size="12">`+"\n",
+		box.w+2*margin, box.h+2*margin, box.w+2*margin, box.h+2*margin)
+	buf.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" markerUnits="userSpaceOnUse" orient="auto"><path d="M0,0 L10,5 L0,10 z" fill="#333"/></marker></defs>` + "\n")
+	box.walkItems(func(item *layoutItem) {
+		failed := style.failed(item.node)
+		if item.sub != nil {
+			stroke := "#888"
+			if failed {
+				stroke = "#c00"
+			}
+			fmt.Fprintf(buf, `<rect x="%d" y="%d" width="%d" height="%d" rx="4" fill="#f4f4f4" stroke="%s" stroke-dasharray="4,3"/>`+"\n",
+				item.x, item.y, item.w, item.h, stroke)
+			fmt.Fprintf(buf, `<text x="%d" y="%d" font-weight="bold" fill="%s">%s</text>`+"\n",
+				item.x+m.clusterPad, item.y+m.lineHeight+4, stroke, html.EscapeString(item.lines[0]))
+			return
+		}
+		fill, stroke, text := "white", "#333", "black"
+		if failed {
+			fill, stroke, text = "#fdd", "#c00", "#c00"
+		}
+		fmt.Fprintf(buf, `<rect x="%d" y="%d" width="%d" height="%d" rx="4" fill="%s" stroke="%s"/>`+"\n",
+			item.x, item.y, item.w, item.h, fill, stroke)
+		for i, line := range item.lines {
+			fmt.Fprintf(buf, `<text x="%d" y="%d" text-anchor="middle" fill="%s">%s</text>`+"\n",
+				item.centerX(), item.y+m.nodePadY+(i+1)*m.lineHeight-4, text, html.EscapeString(line))
+		}
+	})
+	for _, route := range box.routes(m, style) {
+		points := make([]string, len(route.points))
+		for i, p := range route.points {
+			points[i] = fmt.Sprintf("%d,%d", p.x, p.y)
+		}
+		fmt.Fprintf(buf, `<polyline points="%s" fill="none" stroke="#333" stroke-width="%d" marker-end="url(#arrow)"/>`+"\n",
+			strings.Join(points, " "), route.weight)
+		if route.label != "" {
+			end := route.points[len(route.points)-1]
+			fmt.Fprintf(buf, `<text x="%d" y="%d" fill="#555">%s</text>`+"\n",
+				end.x+4, end.y-6, html.EscapeString(route.label))
+		}
+	}
+	buf.WriteString("</svg>\n")
+	return buf.String()
+}
+
+// Lines that leave a cell in each direction
+const (
+	lineUp = 1 << iota
+	lineDown
+	lineLeft
+	lineRight
+)
+
+var boxRunes = map[int]rune{
+	lineUp:                                   '│',
+	lineDown:                                 '│',
+	lineUp | lineDown:                        '│',
+	lineLeft:                                 '─',
+	lineRight:                                '─',
+	lineLeft | lineRight:                     '─',
+	lineDown | lineRight:                     '┌',
+	lineDown | lineLeft:                      '┐',
+	lineUp | lineRight:                       '└',
+	lineUp | lineLeft:                        '┘',
+	lineUp | lineDown | lineRight:            '├',
+	lineUp | lineDown | lineLeft:             '┤',
+	lineDown | lineLeft | lineRight:          '┬',
+	lineUp | lineLeft | lineRight:            '┴',
+	lineUp | lineDown | lineLeft | lineRight: '┼',
+}
+
+// textCanvas is a grid of characters for drawing boxes and lines. Lines
+// that meet in a cell are joined with the right box-drawing character.
+type textCanvas struct {
+	lines [][]int
+	text  [][]rune
+}
+
+func newTextCanvas(w, h int) *textCanvas {
+	canvas := &textCanvas{make([][]int, h), make([][]rune, h)}
+	for y := 0; y < h; y++ {
+		canvas.lines[y] = make([]int, w)
+		canvas.text[y] = make([]rune, w)
+	}
+	return canvas
+}
+
+func (self *textCanvas) inside(x, y int) bool {
+	return y >= 0 && y < len(self.lines) && x >= 0 && x < len(self.lines[y])
+}
+
+func (self *textCanvas) line(x1, y1, x2, y2 int) {
+	for x1 != x2 || y1 != y2 {
+		var out, in int
+		dx, dy := 0, 0
+		switch {
+		case x2 > x1:
+			dx, out, in = 1, lineRight, lineLeft
+		case x2 < x1:
+			dx, out, in = -1, lineLeft, lineRight
+		case y2 > y1:
+			dy, out, in = 1, lineDown, lineUp
+		default:
+			dy, out, in = -1, lineUp, lineDown
+		}
+		if self.inside(x1, y1) {
+			self.lines[y1][x1] |= out
+		}
+		x1 += dx
+		y1 += dy
+		if self.inside(x1, y1) {
+			self.lines[y1][x1] |= in
+		}
+	}
+}
+
+func (self *textCanvas) rect(x, y, w, h int) {
+	self.line(x, y, x+w-1, y)
+	self.line(x+w-1, y, x+w-1, y+h-1)
+	self.line(x+w-1, y+h-1, x, y+h-1)
+	self.line(x, y+h-1, x, y)
+}
+
+// write puts text on the canvas. Cells that already have text, or that
+// have any of the lines in 'blocked', are skipped.
+func (self *textCanvas) write(x, y int, text string, blocked int) {
+	for _, r := range text {
+		if self.inside(x, y) && self.text[y][x] == 0 && self.lines[y][x]&blocked == 0 {
+			self.text[y][x] = r
+		}
+		x++
+	}
+}
+
+// room returns how many cells in a row, starting at x, are free to write
+// text in (see write).
+func (self *textCanvas) room(x, y int, blocked int) int {
+	count := 0
+	for ; self.inside(x, y) && self.text[y][x] == 0 && self.lines[y][x]&blocked == 0; x++ {
+		count++
+	}
+	return count
+}
+
+// crossed returns true if any vertical lines cross a horizontal span.
+func (self *textCanvas) crossed(x, y, width int) bool {
+	for i := x; i < x+width; i++ {
+		if self.inside(i, y) && self.lines[y][i]&(lineUp|lineDown) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *textCanvas) String() string {
+	lines := make([]string, len(self.lines))
+	for y := range self.lines {
+		row := make([]rune, len(self.lines[y]))
+		for x, mask := range self.lines[y] {
+			switch {
+			case self.text[y][x] != 0:
+				row[x] = self.text[y][x]
+			case mask != 0:
+				row[x] = boxRunes[mask]
+			default:
+				row[x] = ' '
+			}
+		}
+		lines[y] = strings.TrimRight(string(row), " ")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Ascii draws the Graph with box-drawing characters, for printing in a
+// terminal. Graphs that are used as Nodes are drawn as a box around their
+// own Nodes. This does not require graphviz.
+func (self *Graph) Ascii() string {
+	return self.ascii(defaultStyle)
+}
+
+// ascii draws the Graph as text. Edge weights and failures can't be shown,
+// so only the labels from 'style' are used.
+func (self *Graph) ascii(style *graphStyle) string {
+	m := textMetrics
+	box := layoutGraph(self, m, style.label)
+	box.place(0, 0, m)
+	canvas := newTextCanvas(box.w, box.h)
+	routes := box.routes(m, style)
+	box.walkItems(func(item *layoutItem) {
+		canvas.rect(item.x, item.y, item.w, item.h)
+		for i, line := range item.lines {
+			if item.sub == nil {
+				x := item.x + (item.w-utf8.RuneCountInString(line))/2
+				canvas.write(x, item.y+m.nodePadY+i, line, 0)
+			}
+		}
+	})
+	for _, route := range routes {
+		for i := 1; i < len(route.points); i++ {
+			a, b := route.points[i-1], route.points[i]
+			canvas.line(a.x, a.y, b.x, b.y)
+		}
+		end := route.points[len(route.points)-1]
+		canvas.write(end.x, end.y, "▼", 0)
+	}
+	// Subgraph labels go in the top border, in a spot where no edges cross
+	// it if possible
+	box.walkItems(func(item *layoutItem) {
+		if item.sub == nil {
+			return
+		}
+		label := " " + item.lines[0] + " "
+		width := utf8.RuneCountInString(label)
+		x := item.x + 2
+		for start := x; start+width < item.x+item.w-1; start++ {
+			if !canvas.crossed(start, item.y, width) {
+				x = start
+				break
+			}
+		}
+		canvas.write(x, item.y, label, lineUp|lineDown)
+	})
+	// Edge labels go to the right of the arrow. Labels that would run into
+	// something else are shortened, or left out if there's no room at all.
+	blocked := lineUp | lineDown | lineLeft | lineRight
+	for _, route := range routes {
+		if route.label == "" {
+			continue
+		}
+		end := route.points[len(route.points)-1]
+		label := []rune(route.label)
+		room := canvas.room(end.x+1, end.y, blocked)
+		if len(label) > room {
+			if room < 2 {
+				continue
+			}
+			label = append(label[:room-1], '…')
+		}
+		canvas.write(end.x+1, end.y, string(label), blocked)
+	}
+	return canvas.String()
+}
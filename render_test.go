@@ -0,0 +1,47 @@
+package pike
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestAsciiPortLabels(t *testing.T) {
+	f := func(ctx context.Context, in, out []chan File) error {
+		return nil
+	}
+	n := source("out")
+	split := n.Pipe(NewPortNode("split", 1, 1, 1, []string{"js", "map", "source"},
+		FxnRunnable(f)))
+	merge := Merge()
+	for _, port := range []string{"js", "map", "source"} {
+		split.Port(port).Pipe(merge)
+	}
+	graph := NewGraph("app")
+	graph.Add(n)
+
+	text := graph.Ascii()
+	if !strings.Contains(text, "▼ ▼ ▼s…\n") {
+		t.Errorf("Expected the labels that don't fit to be shortened or left out, got\n%s", text)
+	}
+}
+
+func TestAsciiClusterLabel(t *testing.T) {
+	sub := NewGraph("css")
+	sub.Add(source())
+	graph := NewGraph("app")
+	graph.Add(sub.Node())
+	style := &graphStyle{
+		label:      func(n *Node) []string { return []string{strings.ToUpper(nodeLabel(n)[0]), "x"} },
+		edgeLabel:  portLabel,
+		edgeWeight: defaultStyle.edgeWeight,
+		failed:     defaultStyle.failed,
+	}
+
+	if text := graph.Ascii(); !strings.Contains(text, "┌─ css ─") {
+		t.Errorf("Expected the cluster to be labeled with the Graph name, got\n%s", text)
+	}
+	if text := graph.ascii(style); !strings.Contains(text, "┌─ CSS ─") {
+		t.Errorf("Expected the cluster to use the style's label, got\n%s", text)
+	}
+}
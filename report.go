@@ -0,0 +1,114 @@
+package pike
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// RenderReport draws the Graphs as they were during a run, with each Node
+// annotated with its stats: the files and bytes that went in and out, how
+// long it ran, and how many errors it reported. Failed Nodes are drawn in
+// red, and each edge is labelled with the number of files that went along
+// it and drawn heavier the more files it carried. The file type is chosen
+// by the extension on 'outfile', like Render, but Mermaid files are not
+// annotated. Several Graphs are drawn side by side. Outputs that were
+// skipped with Port have no edge. If 'stats' is nil, the Graphs are drawn
+// without annotations.
+func RenderReport(outfile string, graphs []*Graph, stats *RunStats) error {
+	style := defaultStyle
+	if stats != nil {
+		style = stats.style()
+	}
+	return reportGraph(graphs).render(outfile, style)
+}
+
+// reportGraph returns a Graph that contains all of 'graphs', for drawing
+// them at once. The Graphs are not copied, so that their Nodes can still be
+// found in the stats.
+func reportGraph(graphs []*Graph) *Graph {
+	if len(graphs) == 1 {
+		return graphs[0]
+	}
+	container := NewGraph("pike")
+	for _, graph := range graphs {
+		container.nodes = append(container.nodes,
+			NewNode(graph.Name, 0, 0, 0, 0, &GraphRunnable{Graph: graph}))
+	}
+	return container
+}
+
+// style annotates a drawing of the Graphs with the stats.
+func (self *RunStats) style() *graphStyle {
+	var failed func(n *Node) bool
+	failed = func(n *Node) bool {
+		if stats := self.byNode[n]; stats != nil && stats.Errors > 0 {
+			return true
+		}
+		if runner, ok := n.Runner.(*GraphRunnable); ok {
+			for _, child := range runner.Graph.nodes {
+				if failed(child) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	files := func(n *Node, port int) int {
+		if port < len(self.edges[n]) {
+			return self.edges[n][port]
+		}
+		return 0
+	}
+	return &graphStyle{
+		label: func(n *Node) []string {
+			stats := self.byNode[n]
+			if stats == nil {
+				return nodeLabel(n)
+			}
+			lines := []string{
+				nodeLabel(n)[0],
+				fmt.Sprintf("files: %d in, %d out", stats.FilesIn, stats.FilesOut),
+				fmt.Sprintf("bytes: %s in, %s out", formatBytes(stats.BytesIn),
+					formatBytes(stats.BytesOut)),
+				fmt.Sprintf("time: %s", round(stats.Wall)),
+			}
+			if stats.Errors == 1 {
+				lines = append(lines, "1 error")
+			} else if stats.Errors > 1 {
+				lines = append(lines, fmt.Sprintf("%d errors", stats.Errors))
+			}
+			return lines
+		},
+		edgeLabel: func(n *Node, port int) string {
+			count := fmt.Sprintf("%d files", files(n, port))
+			if label := portLabel(n, port); label != "" {
+				return label + ": " + count
+			}
+			return count
+		},
+		edgeWeight: func(n *Node, port int) int {
+			// Grows with the log of the number of files
+			weight := 1 + bits.Len(uint(files(n, port)))/2
+			if weight > maxEdgeWeight {
+				weight = maxEdgeWeight
+			}
+			return weight
+		},
+		failed: failed,
+	}
+}
+
+// formatBytes formats a size in bytes with a unit, e.g. "1.5K".
+func formatBytes(size int64) string {
+	units := []string{"K", "M", "G"}
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+	value := float64(size) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
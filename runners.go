@@ -1,16 +1,25 @@
 package pike
 
-// Runnable is the piece of a Node that performs the file operations.
+import (
+	"context"
+	"encoding/json"
+)
+
+// Runnable is the piece of a Node that performs the file operations. Run
+// must close all of the output channels when it is done. If any Files could
+// not be processed, Run should keep going and return all of the failures at
+// the end (see FileError and Errors). If the context is cancelled, Run
+// should stop doing work and return ctx.Err().
 type Runnable interface {
-	Run(in, out []chan File)
+	Run(ctx context.Context, in, out []chan File) error
 	Copy() Runnable
 }
 
 // FxnRunnable is the most simple Runnable. It is just a function.
-type FxnRunnable func(in, out []chan File)
+type FxnRunnable func(ctx context.Context, in, out []chan File) error
 
-func (self FxnRunnable) Run(in, out []chan File) {
-	self(in, out)
+func (self FxnRunnable) Run(ctx context.Context, in, out []chan File) error {
+	return self(ctx, in, out)
 }
 
 func (self FxnRunnable) Copy() Runnable {
@@ -20,12 +29,12 @@ func (self FxnRunnable) Copy() Runnable {
 // CacheRunnable is a function that caches file state between runs. This is
 // used for the change filter nodes.
 type CacheRunnable struct {
-	Fxn   func(in, out []chan File, cache map[string]File)
+	Fxn   func(ctx context.Context, in, out []chan File, cache map[string]File) error
 	Cache map[string]File
 }
 
-func (self *CacheRunnable) Run(in, out []chan File) {
-	self.Fxn(in, out, self.Cache)
+func (self *CacheRunnable) Run(ctx context.Context, in, out []chan File) error {
+	return self.Fxn(ctx, in, out, self.Cache)
 }
 
 func (self *CacheRunnable) Copy() Runnable {
@@ -36,7 +45,36 @@ func (self *CacheRunnable) Copy() Runnable {
 	return &CacheRunnable{self.Fxn, newMap}
 }
 
+type cachedFile struct {
+	Root string `json:"root"`
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+func (self *CacheRunnable) MarshalState() ([]byte, error) {
+	files := make([]cachedFile, 0, len(self.Cache))
+	for _, file := range self.Cache {
+		files = append(files, cachedFile{file.Root(), file.Name(), file.Data()})
+	}
+	return json.Marshal(files)
+}
+
+func (self *CacheRunnable) UnmarshalState(data []byte) error {
+	self.Cache = make(map[string]File)
+	if data == nil {
+		return nil
+	}
+	var files []cachedFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return err
+	}
+	for _, file := range files {
+		self.Cache[file.Name] = NewFile(file.Root, file.Name, file.Data)
+	}
+	return nil
+}
+
 // NewCacheRunnable is a constructor for CacheRunnable.
-func NewCacheRunnable(run func(in, out []chan File, cache map[string]File)) Runnable {
+func NewCacheRunnable(run func(ctx context.Context, in, out []chan File, cache map[string]File) error) Runnable {
 	return &CacheRunnable{run, make(map[string]File)}
 }
@@ -0,0 +1,159 @@
+package pike
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stevearc/pike/plog"
+)
+
+// MemoryServer is an http.Handler that serves the Files that pass through
+// its Nodes, straight from memory. Files are served by their names. Each
+// run of a Graph replaces all of the Files from that Node at once, so
+// requests never see a half-finished run. If the Graph uses a
+// ChangeFilter, put a ChangeCache in front of the MemoryServer so that it
+// sees every File on each run. If more than one Node has a File with the
+// same name, the one from the Node that was created first is served.
+type MemoryServer struct {
+	lock sync.RWMutex
+	// The files from each Node, in the order that the Nodes were created
+	slots []map[string]*servedFile
+}
+
+type servedFile struct {
+	data        []byte
+	etag        string
+	contentType string
+	modTime     time.Time
+}
+
+// NewMemoryServer creates an empty MemoryServer.
+func NewMemoryServer() *MemoryServer {
+	return &MemoryServer{slots: make([]map[string]*servedFile, 0, 1)}
+}
+
+// Serve creates a Node that serves the Files that pass through it on 'addr'
+// (e.g. ":8080"). The server is started the first time the Node runs. Use
+// NewMemoryServer if you want to mount the handler in your own server.
+func Serve(addr string) *Node {
+	server := NewMemoryServer()
+	once := &sync.Once{}
+	start := func() {
+		once.Do(func() {
+			go func() {
+				plog.Info("Serving files from memory on %s", addr)
+				if err := http.ListenAndServe(addr, server); err != nil {
+					plog.Error("Error serving on %s", addr)
+					plog.Exc(err)
+				}
+			}()
+		})
+	}
+	runner := &serveRunnable{server, server.newSlot(), start}
+	node := NewNode("serve", 1, 1, 1, 1, runner)
+	node.Options = fmt.Sprintf("%q", addr)
+	return node
+}
+
+// Node creates a Node that adds Files to the MemoryServer and passes them on
+// unchanged. This means you can Pipe directly into a MemoryServer.
+func (self *MemoryServer) Node() *Node {
+	runner := &serveRunnable{self, self.newSlot(), nil}
+	return NewNode("serve", 1, 1, 1, 1, runner)
+}
+
+func (self *MemoryServer) newSlot() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.slots = append(self.slots, nil)
+	return len(self.slots) - 1
+}
+
+// replace swaps in a new set of Files for one Node.
+func (self *MemoryServer) replace(slot int, files map[string]*servedFile) {
+	self.lock.Lock()
+	self.slots[slot] = files
+	self.lock.Unlock()
+}
+
+func (self *MemoryServer) lookup(name string) *servedFile {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	for _, files := range self.slots {
+		if file, ok := files[name]; ok {
+			return file
+		}
+	}
+	return nil
+}
+
+func (self *MemoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	file := self.lookup(name)
+	if file == nil && strings.HasSuffix(r.URL.Path, "/") {
+		file = self.lookup(path.Join(name, "index.html"))
+	}
+	if file == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", file.contentType)
+	w.Header().Set("ETag", file.etag)
+	http.ServeContent(w, r, name, file.modTime, bytes.NewReader(file.data))
+}
+
+func newServedFile(file File, modTime time.Time) *servedFile {
+	sum := sha256.Sum256(file.Data())
+	contentType := mime.TypeByExtension(filepath.Ext(file.Name()))
+	if contentType == "" {
+		contentType = http.DetectContentType(file.Data())
+	}
+	return &servedFile{
+		data:        file.Data(),
+		etag:        "\"" + hex.EncodeToString(sum[:16]) + "\"",
+		contentType: contentType,
+		modTime:     modTime,
+	}
+}
+
+// serveRunnable collects the Files for one Node of a MemoryServer.
+type serveRunnable struct {
+	server *MemoryServer
+	slot   int
+	// start is called when the Node runs, if set
+	start func()
+}
+
+func (self *serveRunnable) Run(ctx context.Context, in, out []chan File) error {
+	if self.start != nil {
+		self.start()
+	}
+	now := time.Now()
+	files := make(map[string]*servedFile)
+	for file := range in[0] {
+		// Copy the data, since later Nodes may modify the File
+		name := filepath.ToSlash(file.Name())
+		files[name] = newServedFile(file.Copy(), now)
+		out[0] <- file
+	}
+	close(out[0])
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	self.server.replace(self.slot, files)
+	return nil
+}
+
+func (self *serveRunnable) Copy() Runnable {
+	return &serveRunnable{self.server, self.server.newSlot(), self.start}
+}
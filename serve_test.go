@@ -0,0 +1,49 @@
+package pike
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveFiles runs one of the MemoryServer's Nodes on the files.
+func serveFiles(t *testing.T, node *Node, files ...File) {
+	in := make(chan File, len(files))
+	out := make(chan File, len(files))
+	for _, file := range files {
+		in <- file
+	}
+	close(in)
+	if err := node.Runner.Run(context.Background(), []chan File{in}, []chan File{out}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+}
+
+func get(server *MemoryServer, name string) (int, string) {
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/"+name, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestMemoryServerOrder(t *testing.T) {
+	server := NewMemoryServer()
+	first, second := server.Node(), server.Node()
+	serveFiles(t, second, NewFile("src", "a.txt", []byte("second")))
+	serveFiles(t, first, NewFile("src", "a.txt", []byte("first")))
+
+	for i := 0; i < 10; i++ {
+		if _, body := get(server, "a.txt"); body != "first" {
+			t.Fatalf("Expected the first Node's file, got %q", body)
+		}
+	}
+
+	// A run with no files replaces the files from the last run
+	serveFiles(t, first)
+	if _, body := get(server, "a.txt"); body != "second" {
+		t.Errorf("Expected the second Node's file, got %q", body)
+	}
+	serveFiles(t, second)
+	if code, _ := get(server, "a.txt"); code != 404 {
+		t.Errorf("Expected a 404, got %d", code)
+	}
+}
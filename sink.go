@@ -1,11 +1,14 @@
 package pike
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // Sink creates a Node that terminates a branch. It consumes and discards
 // all files it receives.
 func Sink() *Node {
-	f := func(in, out []chan File) {
+	f := func(ctx context.Context, in, out []chan File) error {
 		waitGroup := &sync.WaitGroup{}
 		for _, c := range in {
 			c := c
@@ -17,6 +20,7 @@ func Sink() *Node {
 			}()
 		}
 		waitGroup.Wait()
+		return nil
 	}
 	runner := FxnRunnable(f)
 	return NewNode("sink", 1, -1, 0, 0, runner)
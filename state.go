@@ -0,0 +1,183 @@
+package pike
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/stevearc/pike/plog"
+)
+
+// Stateful is implemented by Runnables that keep state between runs (such
+// as the change filters). If a state directory is set with SetStateDir, the
+// state will be saved after each successful run and loaded again the next
+// time the process starts. Calling UnmarshalState with nil data should
+// reset the state.
+type Stateful interface {
+	MarshalState() ([]byte, error)
+	UnmarshalState(data []byte) error
+}
+
+var stateConfig = struct {
+	Dir string
+}{
+	"",
+}
+
+// SetStateDir sets the directory where Graphs will save the state of their
+// Stateful nodes (e.g. ".pike"). This allows one-shot builds to only process
+// the files that changed since the last build. The state for a Graph is
+// discarded if the structure of the Graph or the Options of its Nodes
+// change.
+func SetStateDir(dir string) {
+	stateConfig.Dir = dir
+}
+
+type graphState struct {
+	Signature string                     `json:"signature"`
+	Nodes     map[string]json.RawMessage `json:"nodes"`
+}
+
+var unsafeChars = regexp.MustCompile("[^A-Za-z0-9_\\-.]")
+
+func (graph *Graph) statePath() string {
+	name := unsafeChars.ReplaceAllString(graph.Name, "_")
+	return filepath.Join(stateConfig.Dir, name+".json")
+}
+
+// stateKey is the identifier for a node in the saved state.
+func stateKey(i int, node *Node) string {
+	return fmt.Sprintf("%d:%s", i, node.Name)
+}
+
+// signature is a hash of the structure of the Graph and the Options of its
+// Nodes. It is used to detect when saved state no longer matches the Graph.
+func (graph *Graph) signature() string {
+	h := sha256.New()
+	graph.writeSignature(h)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (graph *Graph) writeSignature(h hash.Hash) {
+	index := make(map[*Node]int, len(graph.nodes))
+	for i, n := range graph.nodes {
+		index[n] = i
+	}
+	for i, n := range graph.nodes {
+		fmt.Fprintf(h, "%d %q %T %d %d %d %d %q %q\n", i, n.Name, n.Runner,
+			n.MinInputs, n.MaxInputs, n.MinOutputs, n.MaxOutputs, n.OutputPorts,
+			n.Options)
+		for j, next := range n.Outputs {
+			if next != nil {
+				fmt.Fprintf(h, "  %d -> %d\n", j, index[next])
+			}
+		}
+		if runner, ok := n.Runner.(*GraphRunnable); ok {
+			runner.Graph.writeSignature(h)
+		}
+	}
+}
+
+// marshalState collects the state of all Stateful nodes in the Graph.
+func (graph *Graph) marshalState() (*graphState, error) {
+	state := &graphState{graph.signature(), make(map[string]json.RawMessage)}
+	for i, n := range graph.nodes {
+		if stateful, ok := n.Runner.(Stateful); ok {
+			data, err := stateful.MarshalState()
+			if err != nil {
+				return nil, err
+			}
+			state.Nodes[stateKey(i, n)] = data
+		}
+	}
+	return state, nil
+}
+
+// unmarshalState restores the state of all Stateful nodes in the Graph. It
+// returns an error if the state was saved from a different Graph.
+func (graph *Graph) unmarshalState(state *graphState) error {
+	if state.Signature != graph.signature() {
+		return errors.New("graph has changed")
+	}
+	for i, n := range graph.nodes {
+		stateful, ok := n.Runner.(Stateful)
+		data, found := state.Nodes[stateKey(i, n)]
+		if ok && found {
+			if err := stateful.UnmarshalState(data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadState restores the state of the Graph from the state directory. It
+// will only do this once per Graph, since afterwards the state is kept in
+// memory.
+func (graph *Graph) loadState() {
+	if stateConfig.Dir == "" || graph.stateLoaded {
+		return
+	}
+	graph.stateLoaded = true
+	data, err := ioutil.ReadFile(graph.statePath())
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		plog.Warn("Could not read state for %q: %v", graph.Name, err)
+		return
+	}
+	state := &graphState{}
+	if err = json.Unmarshal(data, state); err == nil {
+		err = graph.unmarshalState(state)
+	}
+	if err != nil {
+		plog.Info("Discarding saved state for %q: %v", graph.Name, err)
+		graph.resetState()
+	}
+}
+
+// resetState throws away any partially restored state by resetting all of
+// the Stateful runners.
+func (graph *Graph) resetState() {
+	for _, n := range graph.nodes {
+		if stateful, ok := n.Runner.(Stateful); ok {
+			stateful.UnmarshalState(nil)
+		}
+	}
+}
+
+// saveState writes the state of the Graph to the state directory.
+func (graph *Graph) saveState() error {
+	if stateConfig.Dir == "" {
+		return nil
+	}
+	state, err := graph.marshalState()
+	if err != nil {
+		return err
+	}
+	if len(state.Nodes) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(stateConfig.Dir, os.ModeDir|0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash can't leave behind a
+	// truncated state file
+	path := graph.statePath()
+	tmpfile := path + ".tmp"
+	if err = ioutil.WriteFile(tmpfile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpfile, path)
+}
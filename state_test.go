@@ -0,0 +1,26 @@
+package pike
+
+import "testing"
+
+func TestSignatureIncludesOptions(t *testing.T) {
+	build := func(dest, format string) *Graph {
+		src := Glob("src", "*.js")
+		src.Pipe(ChangeFilter()).Pipe(Rename(format)).Pipe(Write(dest))
+		graph := NewGraph("app")
+		graph.Add(src)
+		return graph
+	}
+	signature := build("build", "{{.Name}}").signature()
+	if other := build("build", "{{.Name}}").signature(); other != signature {
+		t.Errorf("The same Graph has two signatures")
+	}
+	if build("dist", "{{.Name}}").signature() == signature {
+		t.Errorf("Changing the Write destination did not change the signature")
+	}
+	if build("build", "{{.Barename}}.min{{.Ext}}").signature() == signature {
+		t.Errorf("Changing the Rename format did not change the signature")
+	}
+	if build("build", "{{.Name}}").Copy().signature() != signature {
+		t.Errorf("Copying the Graph changed the signature")
+	}
+}
@@ -0,0 +1,400 @@
+package pike
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// NodeStats are the measurements for one Node during one run of a Graph.
+// Durations are in nanoseconds when marshalled to JSON.
+type NodeStats struct {
+	Graph    string `json:"graph"`
+	Node     string `json:"node"`
+	FilesIn  int    `json:"files_in"`
+	FilesOut int    `json:"files_out"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+	// How long the Node's Runnable ran for
+	Wall time.Duration `json:"wall_ns"`
+	// How much of the Wall time the Node spent waiting, either because none
+	// of its inputs had a file ready or because the next Node had not
+	// caught up with its output
+	Blocked time.Duration `json:"blocked_ns"`
+	// The number of errors that the Node reported
+	Errors int `json:"errors"`
+}
+
+// Busy is how long the Node spent doing work (Wall - Blocked).
+func (self *NodeStats) Busy() time.Duration {
+	return self.Wall - self.Blocked
+}
+
+// RunStats are the measurements for every Node in a run of one or more
+// Graphs, including the Nodes inside of subgraphs.
+type RunStats struct {
+	Wall  time.Duration `json:"wall_ns"`
+	Nodes []*NodeStats  `json:"nodes"`
+	// The stats for each Node, and the number of files that were sent on
+	// each of its outputs, for annotating drawings of the Graphs
+	byNode map[*Node]*NodeStats
+	edges  map[*Node][]int
+}
+
+func newRunStats(wall time.Duration) *RunStats {
+	return &RunStats{
+		Wall:   wall,
+		Nodes:  make([]*NodeStats, 0, 10),
+		byNode: make(map[*Node]*NodeStats),
+		edges:  make(map[*Node][]int),
+	}
+}
+
+// Sort orders the Nodes by how long they were busy, slowest first.
+func (self *RunStats) Sort() {
+	sort.SliceStable(self.Nodes, func(i, j int) bool {
+		return self.Nodes[i].Busy() > self.Nodes[j].Busy()
+	})
+}
+
+// Table formats the stats as a table with one row per Node.
+func (self *RunStats) Table() string {
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "graph\tnode\tfiles in\tfiles out\tbytes in\tbytes out\twall\tblocked\tbusy\terrors\t")
+	for _, n := range self.Nodes {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%d\t\n", n.Graph, n.Node,
+			n.FilesIn, n.FilesOut, n.BytesIn, n.BytesOut, round(n.Wall),
+			round(n.Blocked), round(n.Busy()), n.Errors)
+	}
+	w.Flush()
+	fmt.Fprintf(buf, "total: %s\n", round(self.Wall))
+	return buf.String()
+}
+
+// WriteJson writes the stats to a file as JSON.
+func (self *RunStats) WriteJson(filename string) error {
+	data, err := json.MarshalIndent(self, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(100 * time.Microsecond)
+}
+
+// mergeStats combines the stats from several runs that happened at once.
+func mergeStats(wall time.Duration, runs ...*RunStats) *RunStats {
+	stats := newRunStats(wall)
+	for _, run := range runs {
+		stats.Nodes = append(stats.Nodes, run.Nodes...)
+		for n, nodeStats := range run.byNode {
+			stats.byNode[n] = nodeStats
+		}
+		for n, files := range run.edges {
+			stats.edges[n] = files
+		}
+	}
+	return stats
+}
+
+type statsContextKey struct{}
+
+// statsCollector gathers the nodeStats and edgeStats for a run. It is
+// passed down to subgraphs in the context.
+type statsCollector struct {
+	lock  sync.Mutex
+	nodes []*nodeStats
+	edges []*edgeStats
+	// The last time that a file moved along any edge, in Unix nanoseconds
+	lastMove int64
+	// The number of external commands that are running
+	commands int32
+}
+
+func withStatsCollector(ctx context.Context) (context.Context, *statsCollector) {
+	collector := &statsCollector{
+		nodes:    make([]*nodeStats, 0, 10),
+		edges:    make([]*edgeStats, 0, 10),
+		lastMove: time.Now().UnixNano(),
+	}
+	return context.WithValue(ctx, statsContextKey{}, collector), collector
+}
+
+// touch records that the run is still making progress.
+func (self *statsCollector) touch() {
+	if self != nil {
+		atomic.StoreInt64(&self.lastMove, time.Now().UnixNano())
+	}
+}
+
+// idle returns how long it has been since the run made any progress. A
+// run with an external command still running is not idle, since a slow
+// tool can take a long time to finish one file.
+func (self *statsCollector) idle() time.Duration {
+	if atomic.LoadInt32(&self.commands) > 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(&self.lastMove)))
+}
+
+// startCommand records that an external command is running. Call the
+// returned function when it exits.
+func (self *statsCollector) startCommand() func() {
+	if self == nil {
+		return func() {}
+	}
+	atomic.AddInt32(&self.commands, 1)
+	return func() {
+		atomic.AddInt32(&self.commands, -1)
+		self.touch()
+	}
+}
+
+func statsCollectorFrom(ctx context.Context) *statsCollector {
+	collector, _ := ctx.Value(statsContextKey{}).(*statsCollector)
+	return collector
+}
+
+// track starts collecting stats for a Node, and tracing it if 'tracer' is
+// not nil. It returns nil (which is safe to use) if there is no collector.
+func (self *statsCollector) track(graph *Graph, node *Node, tracer *Tracer) *nodeStats {
+	if self == nil {
+		return nil
+	}
+	stats := &nodeStats{stats: NodeStats{Graph: graph.Name, Node: node.Name},
+		node: node, trace: tracer.trackNode(graph, node)}
+	self.lock.Lock()
+	self.nodes = append(self.nodes, stats)
+	self.lock.Unlock()
+	return stats
+}
+
+// trackEdge starts watching an edge between two Nodes, which is output
+// 'port' of 'from'. 'up' or 'down' may be nil if the edge leaves or enters
+// a subgraph, and 'from' is nil if it enters a subgraph.
+func (self *statsCollector) trackEdge(name string, from *Node, port int,
+	up, down *nodeStats) *edgeStats {
+	edge := &edgeStats{name: name, collector: self, from: from, port: port,
+		up: up, down: down, open: 1}
+	if self != nil {
+		self.lock.Lock()
+		self.edges = append(self.edges, edge)
+		self.lock.Unlock()
+	}
+	return edge
+}
+
+func (self *statsCollector) runStats(wall time.Duration) *RunStats {
+	stats := newRunStats(wall)
+	if self == nil {
+		return stats
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, n := range self.nodes {
+		n.lock.Lock()
+		nodeStats := n.stats
+		n.lock.Unlock()
+		stats.Nodes = append(stats.Nodes, &nodeStats)
+		stats.byNode[n.node] = &nodeStats
+	}
+	for _, edge := range self.edges {
+		if edge.from == nil {
+			continue
+		}
+		files := stats.edges[edge.from]
+		for len(files) <= edge.port {
+			files = append(files, 0)
+		}
+		files[edge.port] = int(atomic.LoadInt64(&edge.files))
+		stats.edges[edge.from] = files
+	}
+	return stats
+}
+
+// nodeStats measures a running Node. The links between Nodes report to it
+// when files pass through them, and when they are waiting on either end.
+// All of the methods are safe to call on nil.
+type nodeStats struct {
+	lock  sync.Mutex
+	stats NodeStats
+	node  *Node
+	trace *nodeTrace
+	start time.Time
+	done  bool
+	// The number of inputs that are still open, and how many of them have
+	// no files ready
+	inputs  int
+	starved int
+	// The number of outputs that are full
+	full         int
+	blockedSince time.Time
+}
+
+func (self *nodeStats) blocked() bool {
+	return (self.inputs > 0 && self.starved == self.inputs) || self.full > 0
+}
+
+// update applies a change to the counters and adds to the Blocked time if
+// the change starts or stops the Node from being blocked.
+func (self *nodeStats) update(change func()) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	wasBlocked := self.blocked()
+	change()
+	if self.done || self.start.IsZero() {
+		return
+	}
+	if blocked := self.blocked(); blocked != wasBlocked {
+		now := time.Now()
+		if blocked {
+			self.blockedSince = now
+		} else {
+			self.stats.Blocked += now.Sub(self.blockedSince)
+		}
+	}
+}
+
+func (self *nodeStats) addInput() {
+	self.update(func() {
+		self.inputs++
+		self.starved++
+	})
+}
+
+func (self *nodeStats) closeInput() {
+	self.update(func() {
+		self.inputs--
+		self.starved--
+	})
+}
+
+func (self *nodeStats) setStarved(starved bool) {
+	self.update(func() {
+		if starved {
+			self.starved++
+		} else {
+			self.starved--
+		}
+	})
+}
+
+func (self *nodeStats) setFull(full bool) {
+	self.update(func() {
+		if full {
+			self.full++
+		} else {
+			self.full--
+		}
+	})
+}
+
+func (self *nodeStats) received(file File) {
+	if self == nil {
+		return
+	}
+	self.update(func() {
+		self.stats.FilesIn++
+		self.stats.BytesIn += int64(len(file.Data()))
+	})
+	self.trace.received(file)
+}
+
+func (self *nodeStats) sent(file File) {
+	if self == nil {
+		return
+	}
+	self.update(func() {
+		self.stats.FilesOut++
+		self.stats.BytesOut += int64(len(file.Data()))
+	})
+	self.trace.sent(file)
+}
+
+// failed is called when the Node reports errors.
+func (self *nodeStats) failed(count int) {
+	self.update(func() {
+		self.stats.Errors += count
+	})
+}
+
+// begin is called when the Node starts running.
+func (self *nodeStats) begin() {
+	if self == nil {
+		return
+	}
+	self.trace.begin()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.start = time.Now()
+	if self.blocked() {
+		self.blockedSince = self.start
+	}
+}
+
+// finish is called when the Node's Runnable returns.
+func (self *nodeStats) finish() {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	now := time.Now()
+	if self.blocked() {
+		self.stats.Blocked += now.Sub(self.blockedSince)
+	}
+	self.stats.Wall = now.Sub(self.start)
+	self.done = true
+	self.trace.finish()
+}
+
+// running returns true if the Node has started but not finished.
+func (self *nodeStats) running() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return !self.start.IsZero() && !self.done
+}
+
+// edgeStats tracks the files that are waiting on one edge of the Graph.
+type edgeStats struct {
+	name      string
+	collector *statsCollector
+	from      *Node
+	port      int
+	up        *nodeStats
+	down      *nodeStats
+	// The number of files waiting on the edge, and 1 if the edge is open
+	queued int32
+	open   int32
+	// The number of files that have made it to the other end
+	files int64
+}
+
+// moved is called whenever a file moves onto or off of the edge.
+func (self *edgeStats) moved(queued int) {
+	atomic.StoreInt32(&self.queued, int32(queued))
+	self.collector.touch()
+}
+
+// delivered is called when a file leaves the edge at the downstream end.
+func (self *edgeStats) delivered() {
+	atomic.AddInt64(&self.files, 1)
+}
+
+func (self *edgeStats) close() {
+	atomic.StoreInt32(&self.open, 0)
+	self.collector.touch()
+}
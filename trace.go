@@ -0,0 +1,280 @@
+package pike
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Tracer records what the Nodes of a Graph are doing over time, and writes
+// it out in the Chrome Trace Event format. The traces can be viewed in
+// chrome://tracing or https://ui.perfetto.dev. Each Node gets its own track
+// with a span for the time it ran, a span for each file that it was
+// holding, and a span for each external process that it ran. To trace a
+// run, pass the context from Trace to RunContext or RunAllContext.
+type Tracer struct {
+	lock    sync.Mutex
+	start   time.Time
+	nextTid int
+	events  []traceEvent
+}
+
+type traceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat,omitempty"`
+	Phase     string                 `json:"ph"`
+	Timestamp int64                  `json:"ts"`
+	Duration  int64                  `json:"dur,omitempty"`
+	Pid       int                    `json:"pid"`
+	Tid       int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// NewTracer creates an empty Tracer. All of the times in the trace are
+// relative to when it was created.
+func NewTracer() *Tracer {
+	return &Tracer{start: time.Now(), nextTid: 1, events: make([]traceEvent, 0, 100)}
+}
+
+type tracerContextKey struct{}
+type trackContextKey struct{}
+
+// Trace returns a context that will record Graphs that are run with it in
+// the Tracer.
+func (self *Tracer) Trace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tracerContextKey{}, self)
+}
+
+func tracerFrom(ctx context.Context) *Tracer {
+	tracer, _ := ctx.Value(tracerContextKey{}).(*Tracer)
+	return tracer
+}
+
+// WriteFile writes all of the events recorded so far to a file.
+func (self *Tracer) WriteFile(filename string) error {
+	self.lock.Lock()
+	data, err := json.Marshal(map[string]interface{}{
+		"traceEvents":     self.events,
+		"displayTimeUnit": "ms",
+	})
+	self.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// Reset throws away all of the events recorded so far, and makes the times
+// of later events relative to now. Call it between runs to trace each one
+// separately, since otherwise the events pile up without limit.
+func (self *Tracer) Reset() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.start = time.Now()
+	self.nextTid = 1
+	self.events = make([]traceEvent, 0, 100)
+}
+
+func (self *Tracer) micros(t time.Time) int64 {
+	return int64(t.Sub(self.start) / time.Microsecond)
+}
+
+func (self *Tracer) add(event traceEvent) {
+	self.lock.Lock()
+	self.events = append(self.events, event)
+	self.lock.Unlock()
+}
+
+// newTrack creates a new named track (a "thread" in the trace).
+func (self *Tracer) newTrack(name string) int {
+	self.lock.Lock()
+	tid := self.nextTid
+	self.nextTid++
+	self.lock.Unlock()
+	self.add(traceEvent{Name: "thread_name", Phase: "M", Pid: 1, Tid: tid,
+		Args: map[string]interface{}{"name": name}})
+	self.add(traceEvent{Name: "thread_sort_index", Phase: "M", Pid: 1,
+		Tid: tid, Args: map[string]interface{}{"sort_index": tid}})
+	return tid
+}
+
+func (self *Tracer) span(tid int, category, name string, start, end time.Time,
+	args map[string]interface{}) {
+	duration := self.micros(end) - self.micros(start)
+	if duration == 0 {
+		// Zero-length spans are hard to see, and dur 0 is omitted
+		duration = 1
+	}
+	self.add(traceEvent{Name: name, Category: category, Phase: "X",
+		Timestamp: self.micros(start), Duration: duration, Pid: 1, Tid: tid,
+		Args: args})
+}
+
+// heldFile is a file that a Node has taken from an input, but not yet
+// sent to an output.
+type heldFile struct {
+	file File
+	// The name of the file when the Node took it
+	name  string
+	lane  int
+	start time.Time
+}
+
+// nodeTrace records the spans for one Node. Files that the Node is
+// holding at the same time are put in separate lanes, since spans on the
+// same track must nest. All of the methods are safe to call on nil.
+type nodeTrace struct {
+	tracer *Tracer
+	name   string
+	// The track for the Node itself, which is also the first lane
+	tid   int
+	lock  sync.Mutex
+	start time.Time
+	lanes []int
+	used  []bool
+	held  []*heldFile
+}
+
+func (self *Tracer) trackNode(graph *Graph, node *Node) *nodeTrace {
+	if self == nil {
+		return nil
+	}
+	name := fmt.Sprintf("%s: %s", graph.Name, node.Name)
+	tid := self.newTrack(name)
+	return &nodeTrace{tracer: self, name: name, tid: tid, lanes: []int{tid},
+		used: []bool{false}}
+}
+
+func (self *nodeTrace) begin() {
+	if self == nil {
+		return
+	}
+	self.start = time.Now()
+}
+
+// finish records the span for the Node and for any files it never sent.
+func (self *nodeTrace) finish() {
+	if self == nil {
+		return
+	}
+	now := time.Now()
+	self.lock.Lock()
+	held := self.held
+	self.held = nil
+	self.lock.Unlock()
+	for _, h := range held {
+		self.endFile(h, now)
+	}
+	self.tracer.span(self.tid, "node", self.name, self.start, now, nil)
+}
+
+// received is called when the Node takes a file from one of its inputs.
+func (self *nodeTrace) received(file File) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	lane := 0
+	for lane < len(self.used) && self.used[lane] {
+		lane++
+	}
+	if lane == len(self.used) {
+		name := fmt.Sprintf("%s (%d)", self.name, lane+1)
+		self.lanes = append(self.lanes, self.tracer.newTrack(name))
+		self.used = append(self.used, false)
+	}
+	self.used[lane] = true
+	self.held = append(self.held, &heldFile{file, file.Name(), lane, time.Now()})
+}
+
+// sent is called when the Node sends a file to one of its outputs. It ends
+// the span for that file if the Node was holding it. If the Node made a new
+// file instead, it ends the span for the oldest file the Node is holding.
+func (self *nodeTrace) sent(file File) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	if len(self.held) == 0 {
+		self.lock.Unlock()
+		return
+	}
+	i := 0
+	if reflect.TypeOf(file).Comparable() {
+		for j, h := range self.held {
+			if h.file == file {
+				i = j
+				break
+			}
+		}
+	}
+	h := self.held[i]
+	self.held = append(self.held[:i], self.held[i+1:]...)
+	self.lock.Unlock()
+	self.endFile(h, time.Now())
+}
+
+func (self *nodeTrace) endFile(h *heldFile, end time.Time) {
+	self.lock.Lock()
+	self.used[h.lane] = false
+	tid := self.lanes[h.lane]
+	self.lock.Unlock()
+	self.tracer.span(tid, "file", h.name, h.start, end, nil)
+}
+
+// withNodeTrace returns a context for running a Node, so that the Node can
+// add its own spans with traceSpan.
+func withNodeTrace(ctx context.Context, stats *nodeStats) context.Context {
+	if stats == nil || stats.trace == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, trackContextKey{}, stats.trace)
+}
+
+// laneFor returns the track of the lane that holds a file, or the track
+// for the Node if it isn't holding the file.
+func (self *nodeTrace) laneFor(file File) int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if file != nil && reflect.TypeOf(file).Comparable() {
+		for _, h := range self.held {
+			if h.file == file {
+				return self.lanes[h.lane]
+			}
+		}
+	}
+	return self.tid
+}
+
+// traceSpan starts a span on the track of the Node that is running with
+// 'ctx', in the same lane as 'file' if the Node is holding it. It returns a
+// function that ends the span. It does nothing if the run is not being
+// traced.
+func traceSpan(ctx context.Context, category, name string, file File,
+	args map[string]interface{}) func() {
+	track, _ := ctx.Value(trackContextKey{}).(*nodeTrace)
+	if track == nil {
+		return func() {}
+	}
+	tid := track.laneFor(file)
+	start := time.Now()
+	return func() {
+		track.tracer.span(tid, category, name, start, time.Now(), args)
+	}
+}
+
+// traceCommand starts a span for an external process that a Node runs on
+// a file. Call the returned function when the process exits.
+func traceCommand(ctx context.Context, cmd *Command, file File) func() {
+	return traceSpan(ctx, "exec", filepath.Base(cmd.Name), file,
+		map[string]interface{}{
+			"file": file.Name(),
+			"args": append([]string{cmd.Name}, cmd.Args...),
+		})
+}
@@ -0,0 +1,393 @@
+package pike
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stevearc/pike/plog"
+)
+
+// TransformCache stores the outputs of Nodes on disk, keyed by the data
+// that went into them. A Node wrapped with Wrap will replay the stored
+// outputs when it sees the same input again, instead of running the
+// underlying Node. Because the cache is on disk, results are reused across
+// runs and processes. Files are matched by their data and their name
+// relative to their root, so a project checked out in another directory
+// will use the same entries.
+//
+// Only wrap Nodes whose output depends entirely on a single input File, the
+// options, and the tool versions. For example, a less file that @imports
+// other files will not be rebuilt when only the imported files change.
+type TransformCache struct {
+	Dir string
+	// If greater than 0, the least recently used entries will be evicted
+	// when the cache grows larger than this.
+	MaxBytes int64
+	lock     sync.Mutex
+	// index holds the size and last use of each entry, keyed by path. It is
+	// nil until the directory is read, which happens the first time the
+	// cache needs to know its size.
+	index map[string]*cacheFileInfo
+	// size is the total size of the entries in the index
+	size int64
+}
+
+// NewTransformCache creates a TransformCache that stores entries in 'dir'.
+// If maxBytes is greater than 0, the cache will evict entries to stay
+// under that size.
+func NewTransformCache(dir string, maxBytes int64) *TransformCache {
+	return &TransformCache{Dir: dir, MaxBytes: maxBytes}
+}
+
+var toolVersions = struct {
+	Lock     *sync.Mutex
+	Versions map[string]string
+}{
+	&sync.Mutex{},
+	make(map[string]string),
+}
+
+// ToolVersion returns the output of "tool --version", or "" if the tool
+// could not be run. The tool is run with the CommandRunner from the context.
+// When that is OsCommandRunner, the result is remembered for the life of the
+// process.
+func ToolVersion(ctx context.Context, tool string) string {
+	runner := commandRunnerFrom(ctx)
+	_, cache := runner.(OsCommandRunner)
+	if cache {
+		toolVersions.Lock.Lock()
+		defer toolVersions.Lock.Unlock()
+		if version, ok := toolVersions.Versions[tool]; ok {
+			return version
+		}
+	}
+	var version string
+	result, err := runner.Run(ctx, &Command{Name: tool, Args: []string{"--version"}})
+	if err == nil {
+		version = strings.TrimSpace(string(result.Stdout))
+	}
+	if cache && ctx.Err() == nil {
+		toolVersions.Versions[tool] = version
+	}
+	return version
+}
+
+// Wrap creates a Node that caches the outputs of another Node. 'options'
+// should describe any configuration of the Node that changes its output,
+// and 'tools' are the names of the external programs it runs (by default,
+// the Tools of the Node). Both are part of the cache key, along with the
+// name of the Node and the input File.
+func (self *TransformCache) Wrap(nodeMaker Nodeable, options string, tools ...string) *Node {
+	inner := nodeMaker.Node()
+	if len(tools) == 0 {
+		for _, tool := range inner.Tools {
+			tools = append(tools, tool.Name)
+		}
+	}
+	runner := &CachedRunnable{self, inner.Runner, inner.Name, options, tools}
+	node := NewNode(inner.Name, inner.MinInputs, inner.MaxInputs,
+		inner.MinOutputs, inner.MaxOutputs, runner)
+	node.OutputPorts = inner.OutputPorts
+	node.Tools = inner.Tools
+	node.Options = inner.Options
+	if inner.MaxInputs != 1 {
+		node.problems = append(node.problems, fmt.Sprintf(
+			"%s cannot be cached because it does not have exactly one input",
+			inner.Name))
+	}
+	return node
+}
+
+// CachedRunnable is a Runnable that runs each File through another Runnable
+// individually, and stores the results in a TransformCache.
+type CachedRunnable struct {
+	Cache   *TransformCache
+	Runner  Runnable
+	Name    string
+	Options string
+	Tools   []string
+}
+
+type cacheEntry struct {
+	// Outputs holds the files that were sent to each output. Files with the
+	// same root as the input are stored with an empty root, and are given
+	// the root of the input when they are replayed.
+	Outputs [][]cachedFile `json:"outputs"`
+}
+
+// prefix hashes everything in the cache key except for the input File.
+func (self *CachedRunnable) prefix(ctx context.Context, numOutputs int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %d\n", self.Name, self.Options, numOutputs)
+	for _, tool := range self.Tools {
+		fmt.Fprintf(h, "%q %q\n", tool, ToolVersion(ctx, tool))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// key hashes the prefix with the data of the File and its name. The name is
+// included because it changes the output (e.g. a.less becomes a.css), but
+// the root is not, so that checkouts in different directories can share a
+// cache.
+func (self *CachedRunnable) key(prefix string, file File) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %q %d\n", prefix, file.Name(), len(file.Data()))
+	h.Write(file.Data())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (self *CachedRunnable) Run(ctx context.Context, in, out []chan File) error {
+	var errs Errors
+	prefix := self.prefix(ctx, len(out))
+	for file := range in[0] {
+		if ctx.Err() != nil {
+			continue
+		}
+		key := self.key(prefix, file)
+		entry, err := self.Cache.get(key)
+		if err != nil {
+			plog.Warn("Could not read cache entry for %q: %v", file.Name(), err)
+		}
+		if entry == nil {
+			entry, err = self.transform(ctx, file, len(out))
+			if err != nil {
+				errs.Add(err)
+				continue
+			}
+			if err = self.Cache.put(key, entry); err != nil {
+				plog.Warn("Could not write cache entry for %q: %v", file.Name(), err)
+			}
+		} else {
+			plog.Debug("%s: using cached output for %q", self.Name, file.Name())
+		}
+		for i, files := range entry.Outputs {
+			for _, f := range files {
+				root := f.Root
+				if root == "" {
+					root = file.Root()
+				}
+				out[i] <- NewFile(root, f.Name, f.Data)
+			}
+		}
+	}
+	for _, c := range out {
+		close(c)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return errs.Err()
+}
+
+// transform runs a single File through the wrapped Runnable and collects
+// all of the outputs.
+func (self *CachedRunnable) transform(ctx context.Context, file File, numOutputs int) (*cacheEntry, error) {
+	in := make(chan File, 1)
+	in <- file
+	close(in)
+	out := make([]chan File, numOutputs)
+	entry := &cacheEntry{make([][]cachedFile, numOutputs)}
+	waitGroup := &sync.WaitGroup{}
+	for i := range out {
+		i := i
+		out[i] = make(chan File, 10)
+		waitGroup.Add(1)
+		go func() {
+			entry.Outputs[i] = make([]cachedFile, 0, 1)
+			for f := range out[i] {
+				root := f.Root()
+				if root == file.Root() {
+					root = ""
+				}
+				entry.Outputs[i] = append(entry.Outputs[i],
+					cachedFile{root, f.Name(), f.Data()})
+			}
+			waitGroup.Done()
+		}()
+	}
+	err := self.Runner.Run(ctx, []chan File{in}, out)
+	waitGroup.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (self *CachedRunnable) Copy() Runnable {
+	return &CachedRunnable{self.Cache, self.Runner.Copy(), self.Name,
+		self.Options, self.Tools}
+}
+
+func (self *TransformCache) path(key string) string {
+	return filepath.Join(self.Dir, key[:2], key+".json")
+}
+
+// get returns the entry for a key, or nil if there is none.
+func (self *TransformCache) get(key string) (*cacheEntry, error) {
+	path := self.path(key)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	// Update the modification time so that eviction knows it was used, even
+	// by another process
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	self.lock.Lock()
+	if info, ok := self.index[path]; ok {
+		info.used = now
+	}
+	self.lock.Unlock()
+	return entry, nil
+}
+
+func (self *TransformCache) put(key string, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := self.path(key)
+	if err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0755); err != nil {
+		return err
+	}
+	tmpfile := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err = ioutil.WriteFile(tmpfile, data, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpfile, path); err != nil {
+		return err
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.MaxBytes <= 0 {
+		return nil
+	}
+	if self.index == nil {
+		// The new entry is already on disk, so this picks it up
+		if err = self.load(); err != nil {
+			return err
+		}
+	} else {
+		self.add(path, int64(len(data)), time.Now())
+	}
+	if self.size > self.MaxBytes {
+		return self.prune(self.MaxBytes)
+	}
+	return nil
+}
+
+type cacheFileInfo struct {
+	path string
+	size int64
+	used time.Time
+}
+
+// add records an entry in the index, replacing any earlier one at the same
+// path.
+func (self *TransformCache) add(path string, size int64, used time.Time) {
+	if info, ok := self.index[path]; ok {
+		self.size -= info.size
+	}
+	self.index[path] = &cacheFileInfo{path, size, used}
+	self.size += size
+}
+
+// load reads the sizes and modification times of the entries on disk into
+// the index.
+func (self *TransformCache) load() error {
+	self.index = make(map[string]*cacheFileInfo)
+	self.size = 0
+	return filepath.Walk(self.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".json" {
+			self.add(path, info.Size(), info.ModTime())
+		}
+		return nil
+	})
+}
+
+// Prune deletes the least recently used entries until the cache is no
+// larger than maxBytes.
+func (self *TransformCache) Prune(maxBytes int64) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	// Read the directory again, since other processes may share it
+	if err := self.load(); err != nil {
+		return err
+	}
+	return self.prune(maxBytes)
+}
+
+// prune evicts entries using the index, which must be loaded.
+func (self *TransformCache) prune(maxBytes int64) error {
+	entries := make([]*cacheFileInfo, 0, len(self.index))
+	for _, info := range self.index {
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used.Before(entries[j].used)
+	})
+	removed := 0
+	for _, entry := range entries {
+		if self.size <= maxBytes {
+			break
+		}
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(self.index, entry.path)
+		self.size -= entry.size
+		removed++
+	}
+	plog.Debug("Evicted %d entries from %q", removed, self.Dir)
+	return nil
+}
+
+// ParseSize parses a size in bytes with an optional K, M, or G suffix (e.g.
+// "500M").
+func ParseSize(size string) (int64, error) {
+	multiplier := int64(1)
+	trimmed := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	if len(trimmed) > 0 {
+		switch trimmed[len(trimmed)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+	}
+	n, err := strconv.ParseInt(trimmed, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New(fmt.Sprintf("invalid size %q", size))
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,98 @@
+package pike
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// runCached sends one File through a CachedRunnable and returns the output.
+func runCached(t *testing.T, ctx context.Context, runner *CachedRunnable, file File) File {
+	in := make(chan File, 1)
+	in <- file
+	close(in)
+	out := make(chan File, 1)
+	if err := runner.Run(ctx, []chan File{in}, []chan File{out}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	result, ok := <-out
+	if !ok {
+		t.Fatal("Run had no output")
+	}
+	return result
+}
+
+// compileCalls counts the Commands that weren't checking the version.
+func compileCalls(runner *FakeRunner) int {
+	count := 0
+	for _, call := range runner.Calls() {
+		if len(call.Args) == 0 || call.Args[0] != "--version" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestTransformCacheIgnoresRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runner := NewFakeRunner()
+	runner.OnFunc("lessc", func(cmd *Command) *FakeResponse {
+		return &FakeResponse{Stdout: cmd.Stdin}
+	})
+	ctx := WithCommandRunner(context.Background(), runner)
+	cache := NewTransformCache(dir, 0)
+	node := cache.Wrap(Exec("lessc", ExecConfig{Args: []string{"lessc", "-"}, Ext: ".css"}), "")
+	cached := node.Runner.(*CachedRunnable)
+
+	first := runCached(t, ctx, cached, NewFile("/ci/one/src", "a.less", []byte("a {}")))
+	second := runCached(t, ctx, cached, NewFile("/ci/two/src", "a.less", []byte("a {}")))
+	if first.Fullpath() != "/ci/one/src/a.css" || second.Fullpath() != "/ci/two/src/a.css" {
+		t.Errorf("Unexpected outputs %q and %q", first.Fullpath(), second.Fullpath())
+	}
+	if calls := compileCalls(runner); calls != 1 {
+		t.Errorf("Expected the second file to come from the cache, but lessc ran %d times",
+			calls)
+	}
+
+	runCached(t, ctx, cached, NewFile("/ci/two/src", "b.less", []byte("a {}")))
+	if calls := compileCalls(runner); calls != 2 {
+		t.Errorf("Expected a file with a different name to miss the cache")
+	}
+}
+
+func TestTransformCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	entry := &cacheEntry{[][]cachedFile{{{"", "a.css", []byte("a {}")}}}}
+	cache := NewTransformCache(dir, 1<<20)
+	if err = cache.put("aa", entry); err != nil {
+		t.Fatal(err)
+	}
+	// Room for two entries
+	cache.MaxBytes = 2 * cache.size
+	for _, key := range []string{"bb", "cc"} {
+		time.Sleep(10 * time.Millisecond)
+		if key == "cc" {
+			if found, _ := cache.get("aa"); found == nil {
+				t.Fatal("Expected aa to be in the cache")
+			}
+		}
+		if err = cache.put(key, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, want := range map[string]bool{"aa": true, "bb": false, "cc": true} {
+		if found, _ := cache.get(key); (found != nil) != want {
+			t.Errorf("Expected %s in the cache to be %v", key, want)
+		}
+	}
+}
@@ -1,20 +1,38 @@
 package pike
 
-import "bytes"
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+)
+
+// hashFile creates a File that holds the hash of another File's data. The
+// change filters cache these instead of copies of the whole File.
+func hashFile(file File) File {
+	sum := sha256.Sum256(file.Data())
+	return NewFile(file.Root(), file.Name(), sum[:])
+}
+
+// unchanged returns true if the cache has a hash of the File's current data.
+func unchanged(cache map[string]File, file File) bool {
+	cachedFile, ok := cache[file.Name()]
+	return ok && bytes.Equal(cachedFile.Data(), hashFile(file).Data())
+}
 
 // ChangeFilter will only pass through files that have different data.
-// Useful when you are running a Graph with Watch.
+// Useful when you are running a Graph with Watch, or when using
+// SetStateDir.
 func ChangeFilter() *Node {
-	f := func(in, out []chan File, cache map[string]File) {
+	f := func(ctx context.Context, in, out []chan File, cache map[string]File) error {
 		for file := range in[0] {
-			cachedFile, ok := cache[file.Name()]
-			if ok && bytes.Equal(cachedFile.Data(), file.Data()) {
+			if unchanged(cache, file) {
 				continue
 			}
-			cache[file.Name()] = file.Copy()
+			cache[file.Name()] = hashFile(file)
 			out[0] <- file
 		}
 		close(out[0])
+		return nil
 	}
 	runner := NewCacheRunnable(f)
 	return NewNode("change filter", 1, 1, 1, 1, runner)
@@ -25,27 +43,25 @@ func ChangeFilter() *Node {
 // ChangeFilter for files that implicitly depend on other files, such as a
 // less file with @import.
 func ChangeWatcher() *Node {
-	f := func(in, out []chan File, cache map[string]File) {
+	f := func(ctx context.Context, in, out []chan File, cache map[string]File) error {
 		primaryStream := make([]File, 0)
 		anyChanges := false
 		// Check primary stream for changes
 		for file := range in[0] {
-			cachedFile, ok := cache[file.Name()]
 			primaryStream = append(primaryStream, file)
-			if anyChanges || (ok && bytes.Equal(cachedFile.Data(), file.Data())) {
+			if anyChanges || unchanged(cache, file) {
 				continue
 			}
 			anyChanges = true
-			cache[file.Name()] = file.Copy()
+			cache[file.Name()] = hashFile(file)
 		}
 		// Check all other input streams for changes
 		for _, c := range in[1:] {
 			for file := range c {
-				cachedFile, ok := cache[file.Name()]
-				if anyChanges || (ok && bytes.Equal(cachedFile.Data(), file.Data())) {
+				if anyChanges || unchanged(cache, file) {
 					continue
 				}
-				cache[file.Name()] = file.Copy()
+				cache[file.Name()] = hashFile(file)
 				anyChanges = true
 			}
 		}
@@ -55,6 +71,7 @@ func ChangeWatcher() *Node {
 			}
 		}
 		close(out[0])
+		return nil
 	}
 	runner := NewCacheRunnable(f)
 	return NewNode("change watcher", 2, -1, 1, 1, runner)
@@ -64,7 +81,7 @@ func ChangeWatcher() *Node {
 // through it, and replays them. Works well with ChangeFilter when you have
 // later Nodes that must operate on all files.
 func ChangeCache() *Node {
-	f := func(in, out []chan File, cache map[string]File) {
+	f := func(ctx context.Context, in, out []chan File, cache map[string]File) error {
 		seenFiles := make(map[string]bool)
 		seenAny := false
 		for file := range in[0] {
@@ -81,6 +98,7 @@ func ChangeCache() *Node {
 			}
 		}
 		close(out[0])
+		return nil
 	}
 	runner := NewCacheRunnable(f)
 	return NewNode("change cache", 1, 1, 1, 1, runner)
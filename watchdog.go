@@ -0,0 +1,97 @@
+package pike
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/stevearc/pike/plog"
+)
+
+// How long to wait for the Nodes to stop after a hung Graph is cancelled
+const hangGracePeriod = 10 * time.Second
+
+var hangConfig = struct {
+	Timeout time.Duration
+}{
+	0,
+}
+
+// SetHangTimeout sets how long a Graph may go without moving any files
+// before it is considered deadlocked (e.g. because a Node never closed one
+// of its outputs). When that happens, the Graph is cancelled, and the error
+// lists the Nodes that are still running and the files waiting on each
+// edge. Time spent running an external tool doesn't count, so a slow tool
+// isn't mistaken for a deadlock (use SetToolTimeout to limit those). A
+// timeout of 0 (the default) disables the check.
+func SetHangTimeout(timeout time.Duration) {
+	hangConfig.Timeout = timeout
+}
+
+// dump describes the Nodes that are still running and the edges that are
+// still open.
+func (self *statsCollector) dump() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	lines := make([]string, 0, 10)
+	lines = append(lines, "running nodes:")
+	for _, n := range self.nodes {
+		if n.running() {
+			lines = append(lines, fmt.Sprintf("  %s: %s", n.stats.Graph, n.stats.Node))
+		}
+	}
+	lines = append(lines, "open edges:")
+	for _, edge := range self.edges {
+		if atomic.LoadInt32(&edge.open) == 1 {
+			lines = append(lines, fmt.Sprintf("  %s (%d files waiting)", edge.name,
+				atomic.LoadInt32(&edge.queued)))
+		}
+	}
+	return strings.Join(lines, "\n  ")
+}
+
+// watchForHang cancels the Execution if no files move for 'timeout'. If the
+// Nodes don't stop after being cancelled, the Execution is marked as done
+// anyway so that Wait will return.
+func (self *Execution) watchForHang(timeout time.Duration, cancel context.CancelFunc) {
+	defer cancel()
+	if timeout <= 0 {
+		<-self.done
+		return
+	}
+	interval := timeout / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.done:
+			return
+		case <-ticker.C:
+		}
+		if self.stats.idle() < timeout {
+			continue
+		}
+		err := errors.New(fmt.Sprintf(
+			"no files have moved for %s, the graph may be deadlocked\n  %s",
+			timeout, self.stats.dump()))
+		plog.Error("Cancelling %q: %v", self.graph.Name, err)
+		self.lock.Lock()
+		self.hung = err
+		self.lock.Unlock()
+		cancel()
+		select {
+		case <-self.done:
+		case <-time.After(hangGracePeriod):
+			plog.Error("Some nodes in %q did not stop after being cancelled",
+				self.graph.Name)
+			self.finish()
+		}
+		return
+	}
+}
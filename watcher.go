@@ -0,0 +1,156 @@
+package pike
+
+import (
+	"time"
+
+	"github.com/stevearc/pike/plog"
+)
+
+// fileWatcher reports the paths of files that change under a set of
+// directories. An empty path means that some changes were lost, and any file
+// may have changed.
+type fileWatcher interface {
+	Events() <-chan string
+	Close() error
+}
+
+// globs finds the Glob nodes in the Graph and any subgraphs.
+func (graph *Graph) globs() []*GlobRunnable {
+	globs := make([]*GlobRunnable, 0, 2)
+	for _, n := range graph.nodes {
+		switch runner := n.Runner.(type) {
+		case *GlobRunnable:
+			globs = append(globs, runner)
+		case *GraphRunnable:
+			globs = append(globs, runner.Graph.globs()...)
+		}
+	}
+	return globs
+}
+
+// Matches returns true if a change to the file at 'path' could affect the
+// output of the Graph, because one of its Globs reads that file.
+func (graph *Graph) Matches(path string) bool {
+	for _, glob := range graph.globs() {
+		if glob.Matches(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// affectedGraphs returns the Graphs that read any of the changed files. If
+// 'changed' is nil or contains "" (meaning we don't know what changed), all
+// of the Graphs are returned.
+func affectedGraphs(graphs []*Graph, changed []string) []*Graph {
+	affected := make([]*Graph, 0, len(graphs))
+	for _, graph := range graphs {
+		if changed == nil {
+			affected = append(affected, graph)
+			continue
+		}
+		for _, path := range changed {
+			if path == "" {
+				plog.Info("Lost track of file changes, running %q", graph.Name)
+				affected = append(affected, graph)
+				break
+			} else if graph.Matches(path) {
+				plog.Info("%s changed, running %q", path, graph.Name)
+				affected = append(affected, graph)
+				break
+			}
+		}
+	}
+	return affected
+}
+
+// watchRoots returns all of the directories that need to be watched to
+// detect changes to the files read by the Graphs. It returns false if any
+// of the Graphs read files without a Glob, since those can't be watched.
+func watchRoots(graphs []*Graph) ([]string, bool) {
+	roots := make([]string, 0, len(graphs))
+	seen := make(map[string]bool)
+	for _, graph := range graphs {
+		globs := graph.globs()
+		if len(globs) == 0 {
+			return nil, false
+		}
+		for _, glob := range globs {
+			if !seen[glob.Root] {
+				seen[glob.Root] = true
+				roots = append(roots, glob.Root)
+			}
+		}
+	}
+	return roots, true
+}
+
+// watchLoop calls 'run' every time that files under the Glob roots of the
+// Graphs change, until something is received on 'quit'. Bursts of changes
+// are collected until there have been no new changes for 'settle'. If the
+// files can't be watched, it falls back to calling 'run' every 'settle'
+// with a nil list of changes.
+func watchLoop(graphs []*Graph, settle time.Duration, quit chan int, run func(changed []string)) {
+	var watcher fileWatcher
+	roots, ok := watchRoots(graphs)
+	if ok {
+		var err error
+		watcher, err = newFileWatcher(roots)
+		if err != nil {
+			plog.Warn("Could not watch for changes, falling back to polling: %v", err)
+		}
+	} else {
+		plog.Debug("Some graphs have no Glob nodes, falling back to polling")
+	}
+	if watcher == nil {
+		pollLoop(settle, quit, run)
+		return
+	}
+	defer watcher.Close()
+
+	for {
+		var changed []string
+		select {
+		case <-quit:
+			return
+		case path, ok := <-watcher.Events():
+			if !ok {
+				plog.Warn("File watcher stopped, falling back to polling")
+				pollLoop(settle, quit, run)
+				return
+			}
+			changed = append(changed, path)
+		}
+		// Wait for the changes to settle, since saving a file usually
+		// generates several events
+		timer := time.NewTimer(settle)
+	collect:
+		for {
+			select {
+			case path, ok := <-watcher.Events():
+				if !ok {
+					break collect
+				}
+				changed = append(changed, path)
+				timer.Reset(settle)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		run(changed)
+	}
+}
+
+// pollLoop calls 'run' every 'poll' until something is received on 'quit'.
+func pollLoop(poll time.Duration, quit chan int, run func(changed []string)) {
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+			time.Sleep(poll)
+			run(nil)
+		}
+	}
+}
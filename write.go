@@ -1,6 +1,8 @@
 package pike
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -16,8 +18,13 @@ func Write(dest string) *Node {
 // WriteMode creates a node that writes files to a destination with a
 // specific file mode.
 func WriteMode(dest string, perm os.FileMode) *Node {
-	f := func(in, out chan File) {
+	f := func(ctx context.Context, in, out chan File) error {
+		var errs Errors
 		for file := range in {
+			// Don't write partial results if the Graph was cancelled
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			// Make sure the directory exists
 			fullpath := filepath.Join(dest, file.Name())
 			parent := filepath.Dir(fullpath)
@@ -36,13 +43,17 @@ func WriteMode(dest string, perm os.FileMode) *Node {
 			plog.Info("Writing file %s", fullpath)
 			err := ioutil.WriteFile(fullpath, file.Data(), perm)
 			if err != nil {
-				plog.Error("Error writing file %q", file.Name())
-				plog.Exc(err)
+				errs.Add(FileError(file, err))
+			} else {
+				recordWrite(fullpath)
 			}
 
 			// Pass the file on
 			out <- file
 		}
+		return errs.Err()
 	}
-	return NewFuncNode("write", f)
+	node := NewFuncNode("write", f)
+	node.Options = fmt.Sprintf("%q %o", dest, perm)
+	return node
 }
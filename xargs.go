@@ -1,15 +1,24 @@
 package pike
 
-import "github.com/stevearc/pike/plog"
+import (
+	"context"
+	"errors"
+)
 
 // Fanin creates a node that takes inputs from multiple sources and maps
 // them to its own outputs. This is used to converge multiple branches into a
 // single node.
 func FanIn() *Node {
-	f := func(in, out []chan File) {
+	f := func(ctx context.Context, in, out []chan File) error {
 		if len(in) < len(out) {
-			plog.Error("Fan-in node has fewer inputs than outputs")
-			return
+			for _, c := range out {
+				close(c)
+			}
+			for _, c := range in {
+				for _ = range c {
+				}
+			}
+			return errors.New("Fan-in node has fewer inputs than outputs")
 		}
 		for i, c := range in {
 			i := i
@@ -25,11 +34,21 @@ func FanIn() *Node {
 				}
 			}()
 		}
+		return nil
 	}
 	runner := FxnRunnable(f)
 	return NewNode("fan-in", 1, -1, 1, -1, runner)
 }
 
+// portSlice returns the names of the first n ports, or nil if there are not
+// enough names.
+func portSlice(ports []string, n int) []string {
+	if len(ports) < n {
+		return nil
+	}
+	return ports[:n]
+}
+
 // Xargs copies the target node and connects each of the outputs of the base
 // Node to one of the copies. If 'edges' is 0, Xargs will make an educated
 // guess as to how many output edges to connect.
@@ -46,6 +65,7 @@ func (n1 *Node) Xargs(nodeMaker Nodeable, edges int) *Node {
 		return n1.Pipe(n2)
 	}
 	fanIn := FanIn()
+	fanIn.OutputPorts = portSlice(n1.OutputPorts, edges)
 	for i := 0; i < edges; i++ {
 		n2Copy := n2.Copy()
 		n1.Pipe(n2Copy)